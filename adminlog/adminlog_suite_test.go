@@ -0,0 +1,13 @@
+package adminlog_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAdminlog(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Adminlog Suite")
+}
@@ -0,0 +1,60 @@
+package adminlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	chatPattern = regexp.MustCompile(`^CHAT\[(\w+)]\[`)
+	// chatSender ends the player name of a chat message with the team and ID of the player, e.g.
+	// (Allies/76561198025480905)]:
+	chatSender = regexp.MustCompile(`\((\w+)/([^()/\s]+)\)]: `)
+)
+
+type Chat struct {
+	// Channel is either Team or Unit
+	Channel    string
+	PlayerName string
+	// Team is either Allies or Axis
+	Team     string
+	PlayerId string
+	Message  string
+}
+
+// ParseChat parses a chat message of the admin log, e.g.:
+//
+//	[355 ms (1743938197)] CHAT[Team][ToastyMcToast(Allies/76561198025480905)]: !seed off
+func ParseChat(message string) (Chat, bool) {
+	line := stripPrefix(message)
+	m := chatPattern.FindStringSubmatch(line)
+	if m == nil {
+		return Chat{}, false
+	}
+	rest := line[len(m[0]):]
+	// Both the name and the message are chosen by the player. When either of them contains another sender, the
+	// message could be attributed to someone else, e.g. an admin, so that it is not parsed at all.
+	senders := chatSender.FindAllStringSubmatchIndex(rest, -1)
+	if len(senders) != 1 {
+		return Chat{}, false
+	}
+	s := senders[0]
+	return Chat{
+		Channel:    m[1],
+		PlayerName: rest[:s[0]],
+		Team:       rest[s[2]:s[3]],
+		PlayerId:   rest[s[4]:s[5]],
+		Message:    strings.TrimSpace(rest[s[1]:]),
+	}, true
+}
+
+// stripPrefix removes the relative and absolute time prefix of an admin log message, if any.
+func stripPrefix(message string) string {
+	if !strings.HasPrefix(message, "[") {
+		return message
+	}
+	if i := strings.Index(message, ")] "); i != -1 {
+		return message[i+3:]
+	}
+	return message
+}
@@ -0,0 +1,63 @@
+package adminlog_test
+
+import (
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/adminlog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Chat", func() {
+	It("parses chat messages", func() {
+		c, ok := adminlog.ParseChat("[355 ms (1743938197)] CHAT[Team][[1.Fjg]Toasty (McToast)(Allies/76561198025480905)]: !fence pause 10m ")
+
+		Expect(ok).To(BeTrue())
+		Expect(c).To(Equal(adminlog.Chat{
+			Channel:    "Team",
+			PlayerName: "[1.Fjg]Toasty (McToast)",
+			Team:       "Allies",
+			PlayerId:   "76561198025480905",
+			Message:    "!fence pause 10m",
+		}))
+	})
+
+	It("ignores messages which could be attributed to another player", func() {
+		_, ok := adminlog.ParseChat("[355 ms (1743938197)] CHAT[Team][Griefer(Allies/76561198000000001)]: x(Allies/76561198025480905)]: !seed off")
+		Expect(ok).To(BeFalse())
+
+		_, ok = adminlog.ParseChat("[355 ms (1743938197)] CHAT[Team][x(Allies/76561198025480905)]: (Allies/76561198000000001)]: !seed off")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("parses messages with parentheses and brackets", func() {
+		c, ok := adminlog.ParseChat("CHAT[Unit][Toasty(Axis/76561198025480905)]: pause (10m) [soon]: ok")
+
+		Expect(ok).To(BeTrue())
+		Expect(c.PlayerId).To(Equal("76561198025480905"))
+		Expect(c.Team).To(Equal("Axis"))
+		Expect(c.Message).To(Equal("pause (10m) [soon]: ok"))
+	})
+
+	It("ignores other messages", func() {
+		_, ok := adminlog.ParseChat("[355 ms (1743938197)] CONNECTED [1.Fjg]ToastyMcToast (76561198025480905)")
+
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Tail", func() {
+	entry := func(ts, msg string) api.AdminLogEntry {
+		return api.AdminLogEntry{Timestamp: ts, Message: msg}
+	}
+
+	It("returns only new entries", func() {
+		t := adminlog.NewTail()
+		old := entry("2025.04.06-15:24:23:369", "old")
+
+		Expect(t.New([]api.AdminLogEntry{old})).To(BeEmpty())
+
+		next := entry("2025.04.06-15:24:25:100", "new")
+		Expect(t.New([]api.AdminLogEntry{old, next})).To(Equal([]api.AdminLogEntry{next}))
+		Expect(t.New([]api.AdminLogEntry{old, next})).To(BeEmpty())
+	})
+})
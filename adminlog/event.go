@@ -0,0 +1,128 @@
+package adminlog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// Event is one of the typed entries of the admin log: MatchStart, MatchEnded, Connected, Disconnected, TeamSwitch,
+// Kill or Chat.
+type Event interface {
+	isEvent()
+}
+
+type MatchStart struct {
+	MapName  string
+	GameMode string
+}
+
+type MatchEnded struct {
+	MapName     string
+	GameMode    string
+	AlliedScore int
+	AxisScore   int
+}
+
+type Connected struct {
+	PlayerName string
+	PlayerId   string
+}
+
+type Disconnected struct {
+	PlayerName string
+	PlayerId   string
+}
+
+type TeamSwitch struct {
+	PlayerName string
+	// From and To are either Allies, Axis or None
+	From string
+	To   string
+}
+
+type Kill struct {
+	KillerName string
+	KillerTeam string
+	KillerId   string
+	VictimName string
+	VictimTeam string
+	VictimId   string
+	Weapon     string
+	TeamKill   bool
+}
+
+func (MatchStart) isEvent()   {}
+func (MatchEnded) isEvent()   {}
+func (Connected) isEvent()    {}
+func (Disconnected) isEvent() {}
+func (TeamSwitch) isEvent()   {}
+func (Kill) isEvent()         {}
+func (Chat) isEvent()         {}
+
+var (
+	matchStartPattern = regexp.MustCompile(`^MATCH START (.+) (\w+)$`)
+	matchEndedPattern = regexp.MustCompile("^MATCH ENDED `(.+) (\\w+)` ALLIED \\((\\d+) - (\\d+)\\) AXIS$")
+	playerPattern     = regexp.MustCompile(`^(CONNECTED|DISCONNECTED) (.*) \(([^()]+)\)$`)
+	teamSwitchPattern = regexp.MustCompile(`^TEAMSWITCH (.*) \((\w+) > (\w+)\)$`)
+	killPattern       = regexp.MustCompile(`^(KILL|TEAM KILL): (.*)\((\w+)/([^)]+)\) -> (.*)\((\w+)/([^)]+)\) with (.*)$`)
+)
+
+// Parse returns the typed event of an admin log message. It returns false for messages which are not supported.
+func Parse(message string) (Event, bool) {
+	if c, ok := ParseChat(message); ok {
+		return c, true
+	}
+	message = strings.TrimSpace(stripPrefix(message))
+	if m := matchStartPattern.FindStringSubmatch(message); m != nil {
+		return MatchStart{MapName: m[1], GameMode: m[2]}, true
+	}
+	if m := matchEndedPattern.FindStringSubmatch(message); m != nil {
+		allied, _ := strconv.Atoi(m[3])
+		axis, _ := strconv.Atoi(m[4])
+		return MatchEnded{MapName: m[1], GameMode: m[2], AlliedScore: allied, AxisScore: axis}, true
+	}
+	if m := playerPattern.FindStringSubmatch(message); m != nil {
+		if m[1] == "CONNECTED" {
+			return Connected{PlayerName: m[2], PlayerId: m[3]}, true
+		}
+		return Disconnected{PlayerName: m[2], PlayerId: m[3]}, true
+	}
+	if m := teamSwitchPattern.FindStringSubmatch(message); m != nil {
+		return TeamSwitch{PlayerName: m[1], From: m[2], To: m[3]}, true
+	}
+	if m := killPattern.FindStringSubmatch(message); m != nil {
+		return Kill{
+			KillerName: m[2],
+			KillerTeam: m[3],
+			KillerId:   m[4],
+			VictimName: m[5],
+			VictimTeam: m[6],
+			VictimId:   m[7],
+			Weapon:     m[8],
+			TeamKill:   m[1] == "TEAM KILL",
+		}, true
+	}
+	return nil, false
+}
+
+// Entry is a parsed admin log entry.
+type Entry struct {
+	Time  time.Time
+	Event Event
+}
+
+// Events returns the new and supported entries of the admin log as typed events.
+func (t *Tail) Events(entries []api.AdminLogEntry) (v []Entry) {
+	for _, e := range t.New(entries) {
+		ev, ok := Parse(e.Message)
+		if !ok {
+			continue
+		}
+		v = append(v, Entry{Time: e.ReceivedTime(), Event: ev})
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package adminlog_test
+
+import (
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/adminlog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Event", func() {
+	DescribeTable("parses admin log messages", func(message string, expected adminlog.Event) {
+		e, ok := adminlog.Parse(message)
+
+		Expect(ok).To(BeTrue())
+		Expect(e).To(Equal(expected))
+	},
+		Entry("match start", "[1:23 min (1743938197)] MATCH START SAINTE-MÈRE-ÉGLISE Warfare",
+			adminlog.MatchStart{MapName: "SAINTE-MÈRE-ÉGLISE", GameMode: "Warfare"}),
+		Entry("match ended", "[1:23 min (1743938197)] MATCH ENDED `CARENTAN Warfare` ALLIED (2 - 3) AXIS",
+			adminlog.MatchEnded{MapName: "CARENTAN", GameMode: "Warfare", AlliedScore: 2, AxisScore: 3}),
+		Entry("connected", "[355 ms (1743938197)] CONNECTED [1.Fjg]ToastyMcToast (76561198025480905)",
+			adminlog.Connected{PlayerName: "[1.Fjg]ToastyMcToast", PlayerId: "76561198025480905"}),
+		Entry("disconnected", "[355 ms (1743938197)] DISCONNECTED Toasty (Mc) (76561198025480905)",
+			adminlog.Disconnected{PlayerName: "Toasty (Mc)", PlayerId: "76561198025480905"}),
+		Entry("team switch", "[355 ms (1743938197)] TEAMSWITCH ToastyMcToast (Allies > Axis)",
+			adminlog.TeamSwitch{PlayerName: "ToastyMcToast", From: "Allies", To: "Axis"}),
+		Entry("kill", "[355 ms (1743938197)] KILL: Toasty(Allies/76561198025480905) -> Bread(Axis/76561198025480906) with M1 GARAND",
+			adminlog.Kill{KillerName: "Toasty", KillerTeam: "Allies", KillerId: "76561198025480905", VictimName: "Bread", VictimTeam: "Axis", VictimId: "76561198025480906", Weapon: "M1 GARAND"}),
+		Entry("team kill", "[355 ms (1743938197)] TEAM KILL: Toasty(Allies/76561198025480905) -> Bread(Allies/76561198025480906) with M1 GARAND",
+			adminlog.Kill{KillerName: "Toasty", KillerTeam: "Allies", KillerId: "76561198025480905", VictimName: "Bread", VictimTeam: "Allies", VictimId: "76561198025480906", Weapon: "M1 GARAND", TeamKill: true}),
+		Entry("chat", "[355 ms (1743938197)] CHAT[Unit][Toasty(Axis/76561198025480905)]: hello",
+			adminlog.Chat{Channel: "Unit", PlayerName: "Toasty", Team: "Axis", PlayerId: "76561198025480905", Message: "hello"}),
+	)
+
+	It("does not parse unsupported messages", func() {
+		_, ok := adminlog.Parse("[355 ms (1743938197)] VOTESYS: Player [Toasty] Started a vote")
+
+		Expect(ok).To(BeFalse())
+	})
+
+	It("returns new entries as events", func() {
+		t := adminlog.NewTail()
+		t.New(nil)
+
+		e := t.Events([]api.AdminLogEntry{
+			{Timestamp: "2025.04.06-15:24:23:369", Message: "[355 ms (1743938197)] VOTESYS: Player [Toasty] Started a vote"},
+			{Timestamp: "2025.04.06-15:24:24:369", Message: "[355 ms (1743938198)] CONNECTED Toasty (76561198025480905)"},
+		})
+
+		Expect(e).To(HaveLen(1))
+		Expect(e[0].Event).To(Equal(adminlog.Connected{PlayerName: "Toasty", PlayerId: "76561198025480905"}))
+	})
+})
@@ -0,0 +1,47 @@
+package adminlog
+
+import (
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// Tail keeps track of the admin log entries that were already seen, so that the admin log can be polled with an
+// overlapping time window without handling entries twice.
+type Tail struct {
+	primed bool
+	seen   map[string]time.Time
+}
+
+func NewTail() *Tail {
+	return &Tail{
+		seen: map[string]time.Time{},
+	}
+}
+
+// New returns the entries that were not returned by a previous call, in the order they were received. The entries
+// passed to the first call are considered to be history and are only remembered, not returned.
+func (t *Tail) New(entries []api.AdminLogEntry) (v []api.AdminLogEntry) {
+	var newest time.Time
+	for _, e := range entries {
+		r := e.ReceivedTime()
+		if r.After(newest) {
+			newest = r
+		}
+		k := e.Timestamp + e.Message
+		if _, ok := t.seen[k]; ok {
+			continue
+		}
+		t.seen[k] = r
+		if t.primed {
+			v = append(v, e)
+		}
+	}
+	t.primed = true
+	for k, r := range t.seen {
+		if newest.Sub(r) > 5*time.Minute {
+			delete(t.seen, k)
+		}
+	}
+	return
+}
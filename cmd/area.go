@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+)
+
+// state is the state of a sub numpad for a team, see area.
+type state int
+
+const (
+	allowed state = iota
+	// denied is inside of a deny fence.
+	denied
+	// outside is outside of all allow fences.
+	outside
+)
+
+// area is the area a team is allowed to be in during a simulated session, computed in the same way as the worker
+// does it for a player of the role.
+type area struct {
+	// exempt indicates that the role is exempt from all fences of the server, see data.Server.IsExemptRole.
+	exempt bool
+	// allow and deny are the fences of the team which apply to the session and the role, out of all fences of the team
+	// in teamAllow and teamDeny.
+	allow, deny         []data.Fence
+	teamAllow, teamDeny []data.Fence
+	allowCells          data.CellSet
+	denyCells           data.CellSet
+	// conditional are the cells of fences with a condition, which apply only because of the session.
+	conditional data.CellSet
+}
+
+func newArea(s data.Server, allow, deny []data.Fence, si *api.GetSessionResponse, r api.PlayerRole) *area {
+	a := &area{teamAllow: allow, teamDeny: deny, exempt: s.IsExemptRole(r)}
+	// Like the worker, no fences apply to exempt roles
+	if !a.exempt {
+		for _, f := range allow {
+			if f.Matches(si) && f.AppliesTo(r) {
+				a.allow = append(a.allow, f)
+			}
+		}
+		for _, f := range deny {
+			if f.Matches(si) && f.AppliesTo(r) {
+				a.deny = append(a.deny, f)
+			}
+		}
+	}
+	a.allowCells, a.denyCells = data.FenceCells(a.allow), data.FenceCells(a.deny)
+	for _, f := range append(a.allow, a.deny...) {
+		if f.Condition != nil {
+			cells := f.Cells()
+			a.conditional.Union(&cells)
+		}
+	}
+	return a
+}
+
+// state returns the state of the cell, which is a sub numpad.
+func (a *area) state(c data.Cell) state {
+	switch {
+	case a.denyCells.Has(c):
+		return denied
+	case len(a.allow) != 0 && !a.allowCells.Has(c):
+		return outside
+	}
+	return allowed
+}
+
+// subCell returns the sub numpad at the coordinates of the 90x90 sub numpads of the map, counted from the north-west.
+func subCell(x, y int) data.Cell {
+	return data.Cell{
+		Grid: api.Grid{
+			X:      data.Columns[x/9],
+			Y:      y/9 + 1,
+			Numpad: (2-y%9/3)*3 + x%9/3 + 1,
+		},
+		SubNumpad: (2-y%3)*3 + x%3 + 1,
+	}
+}
+
+// simulation are the flags describing a simulated session, which are shared by the commands evaluating fences.
+type simulation struct {
+	server, set, mapName, mode, team, role string
+	players                                int
+}
+
+func (s *simulation) flags(fs *flag.FlagSet) {
+	fs.StringVar(&s.server, "server", "", "host of the server, the first server by default")
+	fs.StringVar(&s.set, "set", "", "name of the fence set, the default fences by default")
+	fs.StringVar(&s.mapName, "map", "", "map name as reported by the server, e.g. CARENTAN")
+	fs.StringVar(&s.mode, "mode", "Warfare", "game mode as reported by the server")
+	fs.IntVar(&s.players, "players", 0, "number of players")
+	fs.StringVar(&s.team, "team", "", "team to evaluate the fences of, axis or allies")
+	fs.StringVar(&s.role, "role", "Rifleman", "role of the player")
+}
+
+// area returns the area of the team and role in the simulated session.
+func (s *simulation) area(c *data.Config) (*area, error) {
+	server, si, allow, deny, r, err := s.session(c)
+	if err != nil {
+		return nil, err
+	}
+	return newArea(server, allow, deny, si, r), nil
+}
+
+// session returns the server and the simulated session, and the fences and role of the team to evaluate.
+func (s *simulation) session(c *data.Config) (server data.Server, si *api.GetSessionResponse, allow, deny []data.Fence, r api.PlayerRole, err error) {
+	servers := c.EffectiveServers()
+	if len(servers) == 0 {
+		return server, nil, nil, nil, 0, errors.New("no servers configured")
+	}
+	server = servers[0]
+	if s.server != "" {
+		found := false
+		for _, sv := range servers {
+			if sv.Host == s.server {
+				server, found = sv, true
+				break
+			}
+		}
+		if !found {
+			return server, nil, nil, nil, 0, fmt.Errorf("no server with host %s", s.server)
+		}
+	}
+	set, ok := server.FenceSet(s.set)
+	if !ok {
+		return server, nil, nil, nil, 0, fmt.Errorf("no fence set %s", s.set)
+	}
+	switch strings.ToLower(s.team) {
+	case "axis":
+		allow, deny = set.AxisFence, set.AxisDenyFence
+	case "allies":
+		allow, deny = set.AlliesFence, set.AlliesDenyFence
+	default:
+		return server, nil, nil, nil, 0, fmt.Errorf("unknown team %q, use axis or allies", s.team)
+	}
+	r, ok = data.ParseRole(s.role)
+	if !ok {
+		return server, nil, nil, nil, 0, fmt.Errorf("unknown role %s", s.role)
+	}
+	si = &api.GetSessionResponse{MapName: s.mapName, GameMode: s.mode, PlayerCount: s.players}
+	return server, si, allow, deny, r, nil
+}
+
+// String describes the simulated session.
+func (s *simulation) String() string {
+	return fmt.Sprintf("%s %s, %d players, %s %s", s.mapName, s.mode, s.players, s.team, s.role)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/gomega"
+)
+
+// update rewrites the golden files of the tests with the current output, e.g. go test ./cmd -args -update.
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testConfig allows allies in column E and, on Carentan, in F5, with the tank commander in column H as well and a part
+// of E2 denied. Axis are allowed in column F only. The commanders are exempt from all fences.
+const testConfig = `
+Conditions:
+  carentan:
+    Equals:
+      map_name: [CARENTAN]
+Servers:
+  - Host: 127.0.0.1
+    AlliesFence:
+      - X: E
+      - X: F
+        Y: 5
+        Condition: carentan
+      - X: H
+        Roles: [Tank Commander]
+    AlliesDenyFence:
+      - Cells:
+          - E2:5:1,2,3
+    AxisFence:
+      - X: F
+    Roles:
+      ArmyCommander:
+        Exempt: true
+`
+
+// simulate evaluates the fences of the config for the simulated session given as command line arguments.
+func simulate(config string, args ...string) (*area, *simulation) {
+	f, err := os.CreateTemp(os.TempDir(), "config*.yml")
+	Expect(err).ToNot(HaveOccurred())
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(config)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(f.Close()).To(Succeed())
+	c, err := data.LoadConfig(f.Name(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	Expect(err).ToNot(HaveOccurred())
+
+	var sim simulation
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	sim.flags(fs)
+	Expect(fs.Parse(args)).To(Succeed())
+	a, err := sim.area(c)
+	Expect(err).ToNot(HaveOccurred())
+	return a, &sim
+}
+
+// expectGolden compares the output with the golden file in testdata, or writes the golden file with -update.
+func expectGolden(name, output string) {
+	path := "testdata/" + name
+	if *update {
+		Expect(os.MkdirAll("testdata", 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(output), 0644)).To(Succeed())
+	}
+	golden, err := os.ReadFile(path)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(output).To(Equal(string(golden)), "output differs from %s, run the tests with -args -update to update it", path)
+}
@@ -16,9 +16,15 @@ import (
 )
 
 func main() {
+	// Commands print their result to stdout, so that logs go to stderr instead
+	logs := os.Stdout
+	if len(os.Args) > 1 {
+		logs = os.Stderr
+	}
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
-		slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})).Warn("load-env", "error", err)
+		slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelInfo})).Warn("load-env", "error", err)
 	}
 
 	// Set up logger
@@ -26,19 +32,32 @@ func main() {
 	if _, ok := os.LookupEnv("DEBUG"); ok {
 		level = slog.LevelDebug
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	logger := slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: level}))
 
 	// Load configuration
 	configPath := "./config.yml"
 	if path, ok := os.LookupEnv("CONFIG_PATH"); ok {
 		configPath = path
 	}
-	c, err := data.NewConfig(configPath, logger)
+	// Only observing the servers saves the config, commands leave the file as it is unless they write it explicitly
+	load := data.NewConfig
+	if len(os.Args) > 1 {
+		load = data.LoadConfig
+	}
+	c, err := load(configPath, logger)
 	if err != nil {
 		logger.Error("config", "error", err)
 		return
 	}
 
+	if len(os.Args) > 1 {
+		if err := runCommand(c, os.Args[1], os.Args[2:]); err != nil {
+			logger.Error("command", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Save config on exit
 	defer func() {
 		if err := c.Save(); err != nil {
@@ -50,13 +69,16 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Initialize workers
-	workers := make([]*worker.Worker, 0, len(c.Servers))
-	for _, server := range c.Servers {
+	servers := c.EffectiveServers()
+	workers := make([]*worker.Worker, 0, len(servers))
+	for _, server := range servers {
 		pool, err := rconv2.NewConnectionPool(rconv2.ConnectionPoolOptions{
-			Logger:   logger,
-			Hostname: server.Host,
-			Port:     server.Port,
-			Password: server.Password,
+			Logger:             logger,
+			Hostname:           server.Host,
+			Port:               server.Port,
+			Password:           server.Password,
+			MaxOpenConnections: server.MaxOpenConnections(),
+			MaxIdleConnections: server.MaxIdleConnections(),
 		})
 		if err != nil {
 			logger.Error("create-connection-pool", "server", server.Host, "error", err)
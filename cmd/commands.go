@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/floriansw/hll-geofences/data"
+	"gopkg.in/yaml.v3"
+)
+
+// commands are run with the name of the command as the first argument of the executable instead of observing the
+// servers, e.g. `hll-geofences config`.
+var commands = map[string]struct {
+	usage string
+	run   func(c *data.Config, args []string) error
+}{
+	"config":  {"config [host]: prints the effective configuration of all servers or the server with the host", printConfig},
+	"render":  {"render -team axis|allies [-map name] [-mode mode] [-players n] [-role role] [-server host] [-set name] [-image file] [-out path]: draws the allowed area of the team during the simulated session as SVG and PNG", renderFences},
+	"whatif":  {"whatif -team axis|allies [-map name] [-mode mode] [-players n] [-role role] [-server host] [-set name]: prints the allowed area of the team during the simulated session and the fences which apply", whatIf},
+	"compact": {"compact [file]: merges fences and conditions into a smaller, equivalent configuration and writes it to the file or back to the configuration", compactConfig},
+}
+
+func runCommand(c *data.Config, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		var usage []string
+		for _, cmd := range commands {
+			usage = append(usage, "  "+cmd.usage)
+		}
+		sort.Strings(usage)
+		return fmt.Errorf("unknown command %s, available commands are:\n%s", name, strings.Join(usage, "\n"))
+	}
+	return cmd.run(c, args)
+}
+
+// printConfig prints the servers with all settings they inherit from server groups and the defaults, as they are
+// used to observe the servers. Passwords are not printed.
+func printConfig(c *data.Config, args []string) error {
+	servers := slices.Clone(c.EffectiveServers())
+	if len(args) != 0 {
+		servers = slices.DeleteFunc(servers, func(s data.Server) bool { return s.Host != args[0] })
+		if len(servers) == 0 {
+			return fmt.Errorf("no server with host %s", args[0])
+		}
+	}
+	for i := range servers {
+		if servers[i].Password != "" {
+			servers[i].Password = "<redacted>"
+		}
+	}
+	e := yaml.NewEncoder(os.Stdout)
+	e.SetIndent(4)
+	if err := e.Encode(data.Config{Servers: servers}); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// compactConfig writes the config with its fences compacted, see data.Compact, to the file given as argument, or back
+// to the config file.
+func compactConfig(c *data.Config, args []string) error {
+	compact, err := data.Compact(c)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		err = compact.SaveTo(args[0])
+	} else if c.ReadOnly() {
+		err = errors.New("the configuration is not saved as YAML, give a file to write the compacted configuration to")
+	} else {
+		err = compact.Save()
+	}
+	if err != nil {
+		return err
+	}
+	before, after := configStats(c), configStats(compact)
+	fmt.Printf("fences: %d -> %d\nconditions: %d -> %d\nlines: %d -> %d\n", before.fences, after.fences, before.conditions, after.conditions, before.lines, after.lines)
+	return nil
+}
+
+type stats struct {
+	fences, conditions, lines int
+}
+
+// configStats counts the fences and named conditions of the config, and the lines it is saved with.
+func configStats(c *data.Config) stats {
+	s := stats{conditions: len(c.Conditions)}
+	count := func(sv data.Server) {
+		s.fences += len(sv.AxisFence) + len(sv.AlliesFence) + len(sv.AxisDenyFence) + len(sv.AlliesDenyFence)
+		for _, set := range sv.FenceSets {
+			s.fences += len(set.AxisFence) + len(set.AlliesFence) + len(set.AxisDenyFence) + len(set.AlliesDenyFence)
+		}
+	}
+	if c.Defaults != nil {
+		count(*c.Defaults)
+	}
+	for _, g := range c.ServerGroups {
+		count(g)
+	}
+	for _, sv := range c.Servers {
+		count(sv)
+	}
+	for _, g := range c.FenceGroups {
+		s.fences += len(g)
+	}
+	if b, err := yaml.Marshal(c); err == nil {
+		s.lines = strings.Count(string(b), "\n")
+	}
+	return s
+}
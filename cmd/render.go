@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/floriansw/hll-geofences/data"
+)
+
+const (
+	// subSize is the size of a sub numpad in pixels, which makes the map 900 pixels wide and high.
+	subSize = 10
+	mapSize = 90 * subSize
+	// margin is the space for the labels of the SVG around the map.
+	margin = 30
+	// opacity of the colors of the states, which keeps a map image visible below them.
+	opacity = 0.5
+)
+
+var stateColors = map[state]color.RGBA{
+	allowed: {R: 0x2e, G: 0x7d, B: 0x32, A: 0xff},
+	denied:  {R: 0xc6, G: 0x28, B: 0x28, A: 0xff},
+	outside: {R: 0x42, G: 0x42, B: 0x42, A: 0xff},
+}
+
+// renderFences writes the area a team is allowed to be in during a simulated session as SVG and PNG, optionally on top
+// of an image of the map. The output only depends on the config and the flags, so that it can be compared in reviews.
+func renderFences(c *data.Config, args []string) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	var sim simulation
+	sim.flags(fs)
+	imagePath := fs.String("image", "", "image of the map to draw the fences on, PNG or JPEG")
+	out := fs.String("out", "fences", "path of the output files without extension, .svg and .png are added")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a, err := sim.area(c)
+	if err != nil {
+		return err
+	}
+
+	var background []byte
+	var img image.Image
+	if *imagePath != "" {
+		if background, err = os.ReadFile(*imagePath); err != nil {
+			return err
+		}
+		if img, _, err = image.Decode(bytes.NewReader(background)); err != nil {
+			return fmt.Errorf("decode %s: %w", *imagePath, err)
+		}
+	}
+	if err := os.WriteFile(*out+".svg", []byte(renderSVG(a, sim.String(), background)), 0644); err != nil {
+		return err
+	}
+	f, err := os.Create(*out + ".png")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := png.Encode(f, renderPNG(a, img)); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d allow fences, %d deny fences\nwritten to %s.svg and %s.png\n", sim.String(), len(a.allow), len(a.deny), *out, *out)
+	return f.Close()
+}
+
+// renderSVG returns the area as SVG with grid labels, a legend and the title. Numpads with the same state in all sub
+// numpads are drawn as one rectangle, to keep the SVG small.
+func renderSVG(a *area, title string, background []byte) string {
+	var b strings.Builder
+	size := mapSize + 2*margin
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif">`+"\n", size, size+margin, size, size+margin)
+	b.WriteString(`<defs><pattern id="conditional" width="8" height="8" patternUnits="userSpaceOnUse" patternTransform="rotate(45)"><rect width="2" height="8" fill="#000" fill-opacity="0.4"/></pattern></defs>` + "\n")
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", size, size+margin)
+	fmt.Fprintf(&b, `<text x="%d" y="20" font-size="14">%s</text>`+"\n", margin, escapeXML(title))
+	fmt.Fprintf(&b, `<g transform="translate(%d %d)">`+"\n", margin, margin)
+	if background != nil {
+		fmt.Fprintf(&b, `<image width="%d" height="%d" preserveAspectRatio="none" href="data:%s;base64,%s"/>`+"\n", mapSize, mapSize, http.DetectContentType(background), base64.StdEncoding.EncodeToString(background))
+	}
+	for gy := range data.Rows {
+		for gx := range data.Columns {
+			for ny := range 3 {
+				for nx := range 3 {
+					x, y := gx*9+nx*3, gy*9+ny*3
+					if s, ok := numpadState(a, x, y); ok {
+						writeRect(&b, x, y, 3, s, isConditional(a, subCell(x, y)))
+						continue
+					}
+					for sy := range 3 {
+						for sx := range 3 {
+							c := subCell(x+sx, y+sy)
+							writeRect(&b, x+sx, y+sy, 1, a.state(c), isConditional(a, c))
+						}
+					}
+				}
+			}
+		}
+	}
+	for i := 0; i <= 30; i++ {
+		width, stroke := "0.5", "#9e9e9e"
+		if i%3 == 0 {
+			width, stroke = "1.5", "#000"
+		}
+		p := i * 3 * subSize
+		fmt.Fprintf(&b, `<line x1="%d" y1="0" x2="%d" y2="%d" stroke="%s" stroke-width="%s"/>`+"\n", p, p, mapSize, stroke, width)
+		fmt.Fprintf(&b, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%s"/>`+"\n", p, mapSize, p, stroke, width)
+	}
+	b.WriteString("</g>\n")
+	for i, column := range data.Columns {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="14" text-anchor="middle">%s</text>`+"\n", margin+i*9*subSize+9*subSize/2, margin-6, column)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="14" text-anchor="middle">%d</text>`+"\n", margin/2, margin+i*9*subSize+9*subSize/2+5, i+1)
+	}
+	legend := []struct {
+		label string
+		fill  string
+	}{
+		{"allowed", hexColor(stateColors[allowed])},
+		{"denied", hexColor(stateColors[denied])},
+		{"outside", hexColor(stateColors[outside])},
+		{"conditional", "url(#conditional)"},
+	}
+	for i, l := range legend {
+		x := margin + i*150
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="16" height="16" fill="%s" stroke="#000" stroke-width="0.5"/>`, x, size+2, l.fill)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="14">%s</text>`+"\n", x+22, size+15, l.label)
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// numpadState returns the state of the numpad at the coordinates of its north-west sub numpad, if all of its sub
+// numpads have the same state and are either all conditional or not.
+func numpadState(a *area, x, y int) (state, bool) {
+	first := subCell(x, y)
+	s, cond := a.state(first), isConditional(a, first)
+	for sy := range 3 {
+		for sx := range 3 {
+			c := subCell(x+sx, y+sy)
+			if a.state(c) != s || isConditional(a, c) != cond {
+				return 0, false
+			}
+		}
+	}
+	return s, true
+}
+
+func isConditional(a *area, c data.Cell) bool {
+	return a.conditional.Has(c)
+}
+
+func writeRect(b *strings.Builder, x, y, size int, s state, conditional bool) {
+	fmt.Fprintf(b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" fill-opacity="%g"/>`+"\n", x*subSize, y*subSize, size*subSize, size*subSize, hexColor(stateColors[s]), opacity)
+	if conditional {
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="%d" height="%d" fill="url(#conditional)"/>`+"\n", x*subSize, y*subSize, size*subSize, size*subSize)
+	}
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func escapeXML(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+// renderPNG returns the area as image of the map without labels, on top of the image of the map scaled to the size
+// of the area if there is one.
+func renderPNG(a *area, background image.Image) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, mapSize, mapSize))
+	for py := range mapSize {
+		for px := range mapSize {
+			bg := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			if background != nil {
+				bounds := background.Bounds()
+				r, g, b, _ := background.At(bounds.Min.X+px*bounds.Dx()/mapSize, bounds.Min.Y+py*bounds.Dy()/mapSize).RGBA()
+				bg = color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff}
+			}
+			c := subCell(px/subSize, py/subSize)
+			col := blend(bg, stateColors[a.state(c)], opacity)
+			if isConditional(a, c) && (px+py)%8 < 2 {
+				col = blend(col, color.RGBA{A: 0xff}, 0.4)
+			}
+			switch {
+			case px%(9*subSize) < 2 || py%(9*subSize) < 2:
+				col = color.RGBA{A: 0xff}
+			case px%(3*subSize) == 0 || py%(3*subSize) == 0:
+				col = blend(col, color.RGBA{R: 0x9e, G: 0x9e, B: 0x9e, A: 0xff}, 0.8)
+			}
+			img.SetRGBA(px, py, col)
+		}
+	}
+	return img
+}
+
+// blend returns the color c drawn with the opacity on top of bg.
+func blend(bg, c color.RGBA, opacity float64) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a)*(1-opacity) + float64(b)*opacity + 0.5)
+	}
+	return color.RGBA{R: mix(bg.R, c.R), G: mix(bg.G, c.G), B: mix(bg.B, c.B), A: 0xff}
+}
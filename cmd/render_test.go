@@ -0,0 +1,80 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("render", func() {
+	cell := func(grid string, numpad, subNumpad int) data.Cell {
+		return data.Cell{Grid: api.Grid{X: grid[:1], Y: int(grid[1] - '0'), Numpad: numpad}, SubNumpad: subNumpad}
+	}
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	// pixel returns the color of the PNG in the center of the sub numpad.
+	pixel := func(a *area, x, y int) color.RGBA {
+		return renderPNG(a, nil).RGBAAt(x*subSize+subSize/2, y*subSize+subSize/2)
+	}
+
+	It("draws the fences as SVG", func() {
+		a, sim := simulate(testConfig, "-team", "allies", "-map", "CARENTAN", "-players", "20")
+
+		expectGolden("render.svg", renderSVG(a, sim.String(), nil))
+	})
+
+	It("draws the same output for the same arguments", func() {
+		a, sim := simulate(testConfig, "-team", "allies", "-map", "CARENTAN")
+		b, _ := simulate(testConfig, "-team", "allies", "-map", "CARENTAN")
+
+		Expect(renderSVG(a, sim.String(), nil)).To(Equal(renderSVG(b, sim.String(), nil)))
+		Expect(renderPNG(a, nil).Pix).To(Equal(renderPNG(b, nil).Pix))
+	})
+
+	It("draws the fences of the team", func() {
+		allies, _ := simulate(testConfig, "-team", "allies")
+		axis, _ := simulate(testConfig, "-team", "axis")
+
+		Expect(allies.state(cell("E5", 5, 5))).To(Equal(allowed))
+		Expect(axis.state(cell("E5", 5, 5))).To(Equal(outside))
+		Expect(axis.state(cell("F1", 5, 5))).To(Equal(allowed))
+
+		// E5 numpad 5 sub numpad 5 is the sub numpad 40, 40 of the map
+		Expect(pixel(allies, 40, 40)).To(Equal(blend(white, stateColors[allowed], opacity)))
+		Expect(pixel(axis, 40, 40)).To(Equal(blend(white, stateColors[outside], opacity)))
+	})
+
+	It("draws the fences of the role", func() {
+		rifleman, _ := simulate(testConfig, "-team", "allies")
+		tankCommander, _ := simulate(testConfig, "-team", "allies", "-role", "TankCommander")
+
+		Expect(rifleman.state(cell("H5", 5, 5))).To(Equal(outside))
+		Expect(tankCommander.state(cell("H5", 5, 5))).To(Equal(allowed))
+		Expect(tankCommander.allow).To(HaveLen(2))
+	})
+
+	It("draws no fences for exempt roles", func() {
+		commander, _ := simulate(testConfig, "-team", "allies", "-map", "CARENTAN", "-role", "ArmyCommander")
+
+		Expect(commander.exempt).To(BeTrue())
+		Expect(commander.allow).To(BeEmpty())
+		Expect(commander.deny).To(BeEmpty())
+		Expect(commander.state(cell("A1", 5, 5))).To(Equal(allowed))
+		Expect(commander.state(cell("E2", 5, 1))).To(Equal(allowed))
+		Expect(pixel(commander, 4, 4)).To(Equal(blend(white, stateColors[allowed], opacity)))
+	})
+
+	It("draws deny fences and conditional fences", func() {
+		carentan, _ := simulate(testConfig, "-team", "allies", "-map", "CARENTAN")
+		foy, _ := simulate(testConfig, "-team", "allies", "-map", "FOY")
+
+		Expect(carentan.state(cell("E2", 5, 1))).To(Equal(denied))
+		Expect(carentan.state(cell("E2", 5, 4))).To(Equal(allowed))
+		Expect(carentan.state(cell("F5", 5, 5))).To(Equal(allowed))
+		Expect(isConditional(carentan, cell("F5", 5, 5))).To(BeTrue())
+		Expect(isConditional(carentan, cell("E5", 5, 5))).To(BeFalse())
+		Expect(foy.state(cell("F5", 5, 5))).To(Equal(outside))
+	})
+})
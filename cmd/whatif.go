@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/floriansw/hll-geofences/data"
+)
+
+// whatIf prints the area a team is allowed to be in during a simulated session as ASCII grid, together with the fences
+// which apply to it. The area is evaluated with data.Fence.IncludesCell and the fences with data.Fence.Matches, which
+// is what the worker uses as well.
+func whatIf(c *data.Config, args []string) error {
+	fs := flag.NewFlagSet("whatif", flag.ContinueOnError)
+	var sim simulation
+	sim.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a, err := sim.area(c)
+	if err != nil {
+		return err
+	}
+	printWhatIf(os.Stdout, sim.String(), a)
+	return nil
+}
+
+// printWhatIf prints the title, the area as ASCII grid and the fences which apply.
+func printWhatIf(w io.Writer, title string, a *area) {
+	fmt.Fprintln(w, title)
+	printGrid(w, a)
+	fmt.Fprintln(w)
+	printFences(w, "Allow fences", a.teamAllow, a.allow)
+	printFences(w, "Deny fences", a.teamDeny, a.deny)
+	switch {
+	case a.exempt:
+		fmt.Fprintln(w, "The role is exempt from all fences, players with it can go anywhere.")
+	case len(a.allow) == 0 && len(a.deny) == 0:
+		fmt.Fprintln(w, "No fences apply, players of the team can go anywhere.")
+	}
+}
+
+// includes reports whether the sub numpad is inside the allowed area of the fences which apply.
+func (a *area) includes(c data.Cell) bool {
+	in := func(f data.Fence) bool { return f.IncludesCell(c) }
+	if slices.ContainsFunc(a.deny, in) {
+		return false
+	}
+	return len(a.allow) == 0 || slices.ContainsFunc(a.allow, in)
+}
+
+// printGrid prints the map with one character for each numpad: # for a numpad which is allowed entirely, x for one
+// which is denied entirely by deny fences, . for one outside of all allow fences and + for one which is allowed
+// partially.
+func printGrid(w io.Writer, a *area) {
+	separator := "   +" + strings.Repeat("---+", len(data.Columns))
+	fmt.Fprint(w, "    ")
+	for _, column := range data.Columns {
+		fmt.Fprintf(w, " %s  ", column)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, separator)
+	for y := range data.Rows * 3 {
+		if y%3 == 1 {
+			fmt.Fprintf(w, "%2d |", y/3+1)
+		} else {
+			fmt.Fprint(w, "   |")
+		}
+		for x := range len(data.Columns) * 3 {
+			fmt.Fprint(w, numpadChar(a, x*3, y*3))
+			if x%3 == 2 {
+				fmt.Fprint(w, "|")
+			}
+		}
+		fmt.Fprintln(w)
+		if y%3 == 2 {
+			fmt.Fprintln(w, separator)
+		}
+	}
+	fmt.Fprintln(w, "# allowed, + partially allowed, . outside, x denied")
+}
+
+// numpadChar returns the character of the numpad at the coordinates of its north-west sub numpad, see printGrid.
+func numpadChar(a *area, x, y int) string {
+	included, denied := 0, 0
+	for sy := range 3 {
+		for sx := range 3 {
+			c := subCell(x+sx, y+sy)
+			if a.includes(c) {
+				included++
+			} else if slices.ContainsFunc(a.deny, func(f data.Fence) bool { return f.IncludesCell(c) }) {
+				denied++
+			}
+		}
+	}
+	switch {
+	case included == data.Numpads:
+		return "#"
+	case included != 0:
+		return "+"
+	case denied == data.Numpads:
+		return "x"
+	}
+	return "."
+}
+
+// printFences prints the fences which apply grouped by their condition and roles, and how many fences there are.
+func printFences(w io.Writer, title string, all, applied []data.Fence) {
+	if len(all) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s (%d of %d apply):\n", title, len(applied), len(all))
+	var order []string
+	groups := map[string][]string{}
+	for _, f := range applied {
+		var parts []string
+		if f.Condition != nil {
+			parts = append(parts, "condition "+describeCondition(*f.Condition))
+		}
+		if len(f.Roles) != 0 {
+			parts = append(parts, "roles "+strings.Join(f.Roles, ", "))
+		}
+		if len(f.ExceptRoles) != 0 {
+			parts = append(parts, "except roles "+strings.Join(f.ExceptRoles, ", "))
+		}
+		key := strings.Join(parts, "; ")
+		if key == "" {
+			key = "always"
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], f.String())
+	}
+	for _, key := range order {
+		fmt.Fprintf(w, "  %s:\n    %s\n", key, strings.Join(groups[key], " "))
+	}
+}
+
+// describeCondition returns the condition in one line, e.g. map_name in [CARENTAN, FOY], player_count < 50.
+func describeCondition(c data.Condition) string {
+	var parts []string
+	for _, k := range slices.Sorted(maps.Keys(c.Equals)) {
+		parts = append(parts, fmt.Sprintf("%s in [%s]", k, strings.Join(c.Equals[k], ", ")))
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.LessThan)) {
+		parts = append(parts, fmt.Sprintf("%s < %d", k, c.LessThan[k]))
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.GreaterThan)) {
+		parts = append(parts, fmt.Sprintf("%s > %d", k, c.GreaterThan[k]))
+	}
+	if len(parts) == 0 {
+		return "always"
+	}
+	return strings.Join(parts, ", ")
+}
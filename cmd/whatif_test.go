@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("whatif", func() {
+	whatIf := func(config string, args ...string) string {
+		a, sim := simulate(config, args...)
+		var b strings.Builder
+		printWhatIf(&b, sim.String(), a)
+		return b.String()
+	}
+
+	It("prints the allowed area and the fences which apply", func() {
+		expectGolden("whatif.txt", whatIf(testConfig, "-team", "allies", "-map", "CARENTAN", "-players", "20", "-role", "Tank Commander"))
+	})
+
+	It("prints the fences of the team and role only", func() {
+		out := whatIf(testConfig, "-team", "axis", "-map", "FOY")
+
+		Expect(out).To(ContainSubstring("Allow fences (1 of 1 apply):\n  always:\n    F\n"))
+		Expect(out).ToNot(ContainSubstring("Deny fences"))
+		Expect(out).ToNot(ContainSubstring("Tank Commander"))
+	})
+
+	It("prints that exempt roles can go anywhere", func() {
+		out := whatIf(testConfig, "-team", "allies", "-map", "CARENTAN", "-role", "ArmyCommander")
+
+		Expect(out).To(ContainSubstring("Allow fences (0 of 3 apply):\n"))
+		Expect(out).To(ContainSubstring("Deny fences (0 of 1 apply):\n"))
+		Expect(out).To(HaveSuffix("The role is exempt from all fences, players with it can go anywhere.\n"))
+		Expect(strings.Count(out, "|###")).To(Equal(300))
+	})
+
+	It("prints when no fences apply", func() {
+		out := whatIf("Servers: [{Host: 127.0.0.1}]", "-team", "allies")
+
+		Expect(out).To(HaveSuffix("No fences apply, players of the team can go anywhere.\n"))
+		// All 3 lines of the 10 grids in each of the 10 rows are allowed entirely
+		Expect(strings.Count(out, "|###")).To(Equal(300))
+	})
+})
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// Columns are the names of the columns of the grid of a map, from west to east.
+var Columns = []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"}
+
+const (
+	// Rows is the number of rows of the grid of a map, numbered from 1 (north) to 10 (south).
+	Rows = 10
+	// Numpads is the number of numpads of each grid, laid out like the keys of a numpad (7 is in the north-west).
+	// Each numpad is divided into the same number of sub numpads, laid out the same way.
+	Numpads = 9
+	// FullNumpad is the mask of all sub numpads of a numpad, see Area.
+	FullNumpad uint16 = 1<<Numpads - 1
+)
+
+// Cell is a sub numpad of the grid of a map, e.g. E5-7-3, which is the finest unit fences can describe. A SubNumpad
+// of 0 refers to the whole numpad.
+type Cell struct {
+	api.Grid
+	SubNumpad int
+}
+
+func (c Cell) String() string {
+	if c.SubNumpad == 0 {
+		return fmt.Sprintf("%s%d-%d", c.X, c.Y, c.Numpad)
+	}
+	return fmt.Sprintf("%s%d-%d-%d", c.X, c.Y, c.Numpad, c.SubNumpad)
+}
+
+// mask returns the sub numpads of the numpad of the cell referred to by the cell.
+func (c Cell) mask() uint16 {
+	if c.SubNumpad == 0 {
+		return FullNumpad
+	}
+	if c.SubNumpad < 1 || c.SubNumpad > Numpads {
+		return 0
+	}
+	return 1 << (c.SubNumpad - 1)
+}
+
+// Area is a part of a map, described by the sub numpads it covers.
+type Area interface {
+	// Covers returns the sub numpads of the numpad of the grid in the area, as a mask with the bit SubNumpad-1 set
+	// for every covered sub numpad.
+	Covers(g api.Grid) uint16
+}
+
+// CellSet is the set of sub numpads on the grid of a map covered by fences. It allows checking a position against
+// any number of fences in constant time.
+type CellSet [10][Rows][Numpads]uint16
+
+// gridIndex returns the column and row index of the grid, or false if the grid is not on the map.
+func gridIndex(g api.Grid) (x, y int, ok bool) {
+	if len(g.X) != 1 || g.X[0] < 'A' || g.X[0] >= 'A'+byte(len(Columns)) || g.Y < 1 || g.Y > Rows || g.Numpad < 1 || g.Numpad > Numpads {
+		return 0, 0, false
+	}
+	return int(g.X[0] - 'A'), g.Y - 1, true
+}
+
+// Covers implements Area.
+func (c *CellSet) Covers(g api.Grid) uint16 {
+	if x, y, ok := gridIndex(g); ok {
+		return c[x][y][g.Numpad-1]
+	}
+	return 0
+}
+
+// Has reports whether the cell is in the set. A cell without a sub numpad is in the set when the whole numpad is.
+func (c *CellSet) Has(cell Cell) bool {
+	m := cell.mask()
+	return m != 0 && c.Covers(cell.Grid)&m == m
+}
+
+// Add adds the cell to the set. Cells outside the map are ignored.
+func (c *CellSet) Add(cell Cell) {
+	if x, y, ok := gridIndex(cell.Grid); ok {
+		c[x][y][cell.Numpad-1] |= cell.mask()
+	}
+}
+
+// Union adds all cells of o to the set.
+func (c *CellSet) Union(o *CellSet) {
+	for x := range c {
+		for y := range c[x] {
+			for n := range c[x][y] {
+				c[x][y][n] |= o[x][y][n]
+			}
+		}
+	}
+}
+
+// Empty reports whether the set contains no cells.
+func (c *CellSet) Empty() bool {
+	return *c == CellSet{}
+}
+
+// mask returns the given numbers between 1 and 9 as a mask, or all of them if there are none.
+func mask(numbers []int) uint16 {
+	if len(numbers) == 0 {
+		return FullNumpad
+	}
+	var m uint16
+	for _, n := range numbers {
+		if n >= 1 && n <= Numpads {
+			m |= 1 << (n - 1)
+		}
+	}
+	return m
+}
+
+// Cells returns the sub numpads included in the fence.
+func (f Fence) Cells() (c CellSet) {
+	if len(f.CellRanges) != 0 {
+		return FenceCells(f.cellRanges())
+	}
+	numpads, subs := mask(f.Numpads), mask(f.SubNumpads)
+	for x, column := range Columns {
+		if !f.hasColumn(column) {
+			continue
+		}
+		for y := range Rows {
+			if !f.hasRow(y + 1) {
+				continue
+			}
+			for n := range Numpads {
+				if numpads&(1<<n) != 0 {
+					c[x][y][n] = subs
+				}
+			}
+		}
+	}
+	return
+}
+
+// FenceCells returns the union of the sub numpads included in the fences.
+func FenceCells(fences []Fence) (c CellSet) {
+	for _, f := range fences {
+		fc := f.Cells()
+		c.Union(&fc)
+	}
+	return
+}
+
+// Adjacent returns the numpads next to the numpad of the grid, including diagonally adjacent ones, which are on the
+// map. Adjacent numpads might be in a neighbouring grid.
+func Adjacent(g api.Grid) (adjacent []api.Grid) {
+	x, y, ok := gridIndex(g)
+	if !ok {
+		return nil
+	}
+	// Coordinates of the numpad on a 30x30 map of numpads, from the north-west
+	nx, ny := x*3+(g.Numpad-1)%3, y*3+2-(g.Numpad-1)/3
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			ax, ay := nx+dx, ny+dy
+			if (dx == 0 && dy == 0) || ax < 0 || ay < 0 || ax >= len(Columns)*3 || ay >= Rows*3 {
+				continue
+			}
+			adjacent = append(adjacent, api.Grid{X: Columns[ax/3], Y: ay/3 + 1, Numpad: (2-ay%3)*3 + ax%3 + 1})
+		}
+	}
+	return
+}
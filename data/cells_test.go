@@ -0,0 +1,107 @@
+package data_test
+
+import (
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cells", func() {
+	It("contains exactly the numpads included by the fence", func() {
+		fences := []data.Fence{
+			{X: Pointer("G"), Y: Pointer(4), Numpads: []int{7, 8}},
+			{X: Pointer("A")},
+			{Y: Pointer(10), Numpads: []int{2}},
+		}
+		for _, f := range fences {
+			c := f.Cells()
+			for _, x := range data.Columns {
+				for y := 1; y <= data.Rows; y++ {
+					for n := 1; n <= data.Numpads; n++ {
+						g := api.Grid{X: x, Y: y, Numpad: n}
+						Expect(c.Has(data.Cell{Grid: g})).To(Equal(f.Includes(g)), "%s %s", f, g)
+					}
+				}
+			}
+		}
+	})
+
+	It("includes the whole map for an empty fence", func() {
+		c := data.Fence{}.Cells()
+		Expect(c.Has(data.Cell{Grid: api.Grid{X: "A", Y: 1, Numpad: 1}})).To(BeTrue())
+		Expect(c.Has(data.Cell{Grid: api.Grid{X: "J", Y: 10, Numpad: 9}, SubNumpad: 9})).To(BeTrue())
+	})
+
+	It("combines fences", func() {
+		c := data.FenceCells([]data.Fence{{X: Pointer("A"), Y: Pointer(1)}, {X: Pointer("B"), Y: Pointer(2), Numpads: []int{5}}})
+		Expect(c.Has(data.Cell{Grid: api.Grid{X: "A", Y: 1, Numpad: 3}})).To(BeTrue())
+		Expect(c.Has(data.Cell{Grid: api.Grid{X: "B", Y: 2, Numpad: 5}})).To(BeTrue())
+		Expect(c.Has(data.Cell{Grid: api.Grid{X: "B", Y: 2, Numpad: 4}})).To(BeFalse())
+		Expect(c.Empty()).To(BeFalse())
+		empty := data.FenceCells(nil)
+		Expect(empty.Empty()).To(BeTrue())
+	})
+
+	Context("sub numpads", func() {
+		f := data.Fence{X: Pointer("E"), Y: Pointer(5), Numpads: []int{7}, SubNumpads: []int{3, 6}}
+
+		It("contains only the sub numpads of the fence", func() {
+			c := f.Cells()
+			numpad := api.Grid{X: "E", Y: 5, Numpad: 7}
+			for sub := 1; sub <= data.Numpads; sub++ {
+				cell := data.Cell{Grid: numpad, SubNumpad: sub}
+				Expect(c.Has(cell)).To(Equal(sub == 3 || sub == 6), "%s", cell)
+				Expect(f.IncludesCell(cell)).To(Equal(sub == 3 || sub == 6), "%s", cell)
+			}
+			Expect(c.Has(data.Cell{Grid: numpad})).To(BeFalse())
+			Expect(c.Covers(numpad)).To(Equal(uint16(1<<2 | 1<<5)))
+			Expect(c.Covers(api.Grid{X: "E", Y: 5, Numpad: 8})).To(BeZero())
+		})
+
+		It("still includes the numpad", func() {
+			Expect(f.Includes(api.Grid{X: "E", Y: 5, Numpad: 7})).To(BeTrue())
+			Expect(f.IncludesCell(data.Cell{Grid: api.Grid{X: "E", Y: 5, Numpad: 7}})).To(BeFalse())
+		})
+
+		It("are described", func() {
+			Expect(f.String()).To(ContainSubstring("Sub 3,6"))
+			Expect(data.Cell{Grid: api.Grid{X: "E", Y: 5, Numpad: 7}, SubNumpad: 3}.String()).To(Equal("E5-7-3"))
+			Expect(data.Cell{Grid: api.Grid{X: "E", Y: 5, Numpad: 7}}.String()).To(Equal("E5-7"))
+		})
+	})
+
+	Context("Adjacent", func() {
+		It("returns the numpads around a numpad within a grid", func() {
+			Expect(data.Adjacent(api.Grid{X: "E", Y: 5, Numpad: 5})).To(ConsistOf(
+				api.Grid{X: "E", Y: 5, Numpad: 1}, api.Grid{X: "E", Y: 5, Numpad: 2}, api.Grid{X: "E", Y: 5, Numpad: 3},
+				api.Grid{X: "E", Y: 5, Numpad: 4}, api.Grid{X: "E", Y: 5, Numpad: 6},
+				api.Grid{X: "E", Y: 5, Numpad: 7}, api.Grid{X: "E", Y: 5, Numpad: 8}, api.Grid{X: "E", Y: 5, Numpad: 9},
+			))
+		})
+
+		It("returns numpads of neighbouring grids", func() {
+			Expect(data.Adjacent(api.Grid{X: "E", Y: 5, Numpad: 9})).To(ConsistOf(
+				api.Grid{X: "E", Y: 4, Numpad: 2}, api.Grid{X: "E", Y: 4, Numpad: 3}, api.Grid{X: "F", Y: 4, Numpad: 1},
+				api.Grid{X: "E", Y: 5, Numpad: 8}, api.Grid{X: "F", Y: 5, Numpad: 7},
+				api.Grid{X: "E", Y: 5, Numpad: 5}, api.Grid{X: "E", Y: 5, Numpad: 6}, api.Grid{X: "F", Y: 5, Numpad: 4},
+			))
+		})
+
+		It("omits numpads outside the map", func() {
+			Expect(data.Adjacent(api.Grid{X: "A", Y: 1, Numpad: 7})).To(ConsistOf(
+				api.Grid{X: "A", Y: 1, Numpad: 8}, api.Grid{X: "A", Y: 1, Numpad: 4}, api.Grid{X: "A", Y: 1, Numpad: 5},
+			))
+		})
+	})
+
+	It("ignores grids outside the map", func() {
+		var c data.CellSet
+		c.Add(data.Cell{Grid: api.Grid{X: "K", Y: 1, Numpad: 1}})
+		c.Add(data.Cell{Grid: api.Grid{X: "A", Y: 11, Numpad: 1}})
+		c.Add(data.Cell{Grid: api.Grid{X: "A", Y: 1, Numpad: 0}})
+		c.Add(data.Cell{Grid: api.Grid{X: "A", Y: 1, Numpad: 1}, SubNumpad: 10})
+		Expect(c.Empty()).To(BeTrue())
+		Expect(c.Has(data.Cell{Grid: api.Grid{X: "K", Y: 1, Numpad: 1}})).To(BeFalse())
+	})
+})
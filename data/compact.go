@@ -0,0 +1,485 @@
+package data
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"gopkg.in/yaml.v3"
+)
+
+// Compact returns a config which behaves the same as c, with fewer and shorter fences. Fences with the same condition
+// and roles are merged into one fence, which lists the cells covered by any of them as ranges, so that duplicate,
+// redundant and overlapping fences disappear. Conditions used more than once are named. Fence groups are replaced by
+// their fences, so that they are not part of the compacted config.
+//
+// The compacted config is saved and read again, and returned only if it is Equivalent to c.
+func Compact(c *Config) (*Config, error) {
+	compact := *c
+	compact.FenceGroups = nil
+	k := compactor{named: map[string]string{}, conditions: map[string]Condition{}, uses: map[string]int{}}
+	for name, cond := range c.Conditions {
+		k.named[conditionKey(cond)] = name
+	}
+
+	servers := func(s Server) Server {
+		s.AxisFence = k.fences(s.AxisFence)
+		s.AlliesFence = k.fences(s.AlliesFence)
+		s.AxisDenyFence = k.fences(s.AxisDenyFence)
+		s.AlliesDenyFence = k.fences(s.AlliesDenyFence)
+		if s.FenceSets != nil {
+			sets := make(map[string]FenceSet, len(s.FenceSets))
+			for _, name := range slices.Sorted(maps.Keys(s.FenceSets)) {
+				set := s.FenceSets[name]
+				sets[name] = FenceSet{
+					AxisFence:       k.fences(set.AxisFence),
+					AlliesFence:     k.fences(set.AlliesFence),
+					AxisDenyFence:   k.fences(set.AxisDenyFence),
+					AlliesDenyFence: k.fences(set.AlliesDenyFence),
+				}
+			}
+			s.FenceSets = sets
+		}
+		return s
+	}
+	if c.Defaults != nil {
+		d := servers(*c.Defaults)
+		compact.Defaults = &d
+	}
+	if c.ServerGroups != nil {
+		compact.ServerGroups = map[string]Server{}
+		for _, name := range slices.Sorted(maps.Keys(c.ServerGroups)) {
+			compact.ServerGroups[name] = servers(c.ServerGroups[name])
+		}
+	}
+	compact.Servers = make([]Server, len(c.Servers))
+	for i, s := range c.Servers {
+		compact.Servers[i] = servers(s)
+	}
+	compact.Conditions = k.name(&compact)
+
+	// Read the compacted config as it would be saved, so that the proof covers saving it as well
+	b, err := yaml.Marshal(&compact)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := decodeConfig(c.path, b)
+	if err != nil {
+		return nil, fmt.Errorf("read compacted config: %w", err)
+	}
+	if err := saved.prepare(); err != nil {
+		return nil, fmt.Errorf("read compacted config: %w", err)
+	}
+	saved.path, saved.readOnly = c.path, c.readOnly
+	if err := Equivalent(c, &saved); err != nil {
+		return nil, fmt.Errorf("compacted config is not equivalent: %w", err)
+	}
+	return &saved, nil
+}
+
+// compactor merges fences and collects their conditions to name them.
+type compactor struct {
+	// named are the names of the conditions of the config by their key, see conditionKey.
+	named map[string]string
+	// conditions are the conditions of the compacted fences by their key, and uses the number of fences using them.
+	conditions map[string]Condition
+	uses       map[string]int
+}
+
+// fences returns the fences merged by their condition and roles, in the order of the first fence of each merged
+// fence.
+func (k *compactor) fences(fences []Fence) []Fence {
+	if fences == nil {
+		return nil
+	}
+	type merged struct {
+		fence    Fence
+		cells    CellSet
+		original Fence
+	}
+	var order []string
+	byKey := map[string]*merged{}
+	for _, f := range expandGroups(fences) {
+		cond := ""
+		if f.Condition != nil {
+			cond = conditionKey(*f.Condition)
+		}
+		key := fmt.Sprintf("%s|%q|%q", cond, f.Roles, f.ExceptRoles)
+		m, ok := byKey[key]
+		if !ok {
+			m = &merged{fence: Fence{Roles: f.Roles, ExceptRoles: f.ExceptRoles}, original: f}
+			if f.Condition != nil {
+				c := normalizeCondition(*f.Condition)
+				m.fence.Condition = &c
+				k.conditions[cond] = c
+			}
+			byKey[key] = m
+			order = append(order, key)
+		}
+		cells := f.Cells()
+		m.cells.Union(&cells)
+	}
+	compacted := make([]Fence, 0, len(order))
+	for _, key := range order {
+		m := byKey[key]
+		if m.cells.Empty() {
+			// The fences cover nothing, which still makes the whole map outside of them, keep one of them as it is
+			m.original.conditionName = ""
+			compacted = append(compacted, m.original)
+			continue
+		}
+		m.fence.CellRanges = m.cells.Ranges()
+		if m.fence.Condition != nil {
+			k.uses[conditionKey(*m.fence.Condition)]++
+		}
+		compacted = append(compacted, m.fence)
+	}
+	return compacted
+}
+
+// name names the conditions used by more than one fence of the config, keeps the names of the conditions named
+// already, and returns the named conditions.
+func (k *compactor) name(c *Config) map[string]Condition {
+	names := map[string]string{}
+	taken := map[string]bool{}
+	for _, key := range slices.Sorted(maps.Keys(k.uses)) {
+		if _, ok := k.named[key]; !ok && k.uses[key] < 2 {
+			continue
+		}
+		name, ok := k.named[key]
+		if !ok {
+			name = conditionName(k.conditions[key])
+			for i := 2; taken[name] || slices.Contains(slices.Collect(maps.Values(k.named)), name); i++ {
+				name = conditionName(k.conditions[key]) + "-" + strconv.Itoa(i)
+			}
+		}
+		taken[name] = true
+		names[key] = name
+	}
+	conditions := map[string]Condition{}
+	each := func(fences []Fence) {
+		for i := range fences {
+			f := &fences[i]
+			if f.Condition == nil || len(f.CellRanges) == 0 {
+				continue
+			}
+			key := conditionKey(*f.Condition)
+			if name, ok := names[key]; ok {
+				f.conditionName = name
+				conditions[name] = k.conditions[key]
+			}
+		}
+	}
+	servers := []*Server{c.Defaults}
+	for _, name := range slices.Sorted(maps.Keys(c.ServerGroups)) {
+		s := c.ServerGroups[name]
+		servers = append(servers, &s)
+	}
+	for i := range c.Servers {
+		servers = append(servers, &c.Servers[i])
+	}
+	for _, s := range servers {
+		if s == nil {
+			continue
+		}
+		each(s.AxisFence)
+		each(s.AlliesFence)
+		each(s.AxisDenyFence)
+		each(s.AlliesDenyFence)
+		for _, set := range s.FenceSets {
+			each(set.AxisFence)
+			each(set.AlliesFence)
+			each(set.AxisDenyFence)
+			each(set.AlliesDenyFence)
+		}
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return conditions
+}
+
+// normalizeCondition returns the condition with the values of Equals sorted, which matches the same game states.
+func normalizeCondition(c Condition) Condition {
+	n := Condition{LessThan: c.LessThan, GreaterThan: c.GreaterThan}
+	if c.Equals != nil {
+		n.Equals = make(map[string][]string, len(c.Equals))
+		for k, v := range c.Equals {
+			n.Equals[k] = slices.Compact(slices.Sorted(slices.Values(v)))
+		}
+	}
+	return n
+}
+
+// conditionKey returns a key which is the same for conditions matching the same game states.
+func conditionKey(c Condition) string {
+	b, _ := yaml.Marshal(normalizeCondition(c))
+	return string(b)
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// conditionName returns a name describing the condition, e.g. carentan-and-3-more-below-50.
+func conditionName(c Condition) string {
+	var parts []string
+	if names := c.Equals["map_name"]; len(names) != 0 {
+		parts = append(parts, names[0])
+		if len(names) > 1 {
+			parts = append(parts, fmt.Sprintf("and %d more", len(names)-1))
+		}
+	} else if modes := c.Equals["game_mode"]; len(modes) != 0 {
+		parts = append(parts, strings.Join(modes, " "))
+	}
+	if v, ok := c.LessThan["player_count"]; ok {
+		parts = append(parts, fmt.Sprintf("below %d", v))
+	}
+	if v, ok := c.GreaterThan["player_count"]; ok {
+		parts = append(parts, fmt.Sprintf("above %d", v))
+	}
+	name := strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(strings.Join(parts, " ")), "-"), "-")
+	if name == "" {
+		return "condition"
+	}
+	return name
+}
+
+// Ranges returns the cells of the set in the short form of Fence.CellRanges. Grids with the same numpads and sub
+// numpads are combined into rectangles.
+func (c *CellSet) Ranges() []string {
+	var covered [10][Rows]bool
+	var ranges []string
+	for y := range Rows {
+		for x := range Columns {
+			sig := c[x][y]
+			if covered[x][y] || sig == [Numpads]uint16{} {
+				continue
+			}
+			// Extend the rectangle to the east as far as possible, then to the south as long as all grids match
+			w := 1
+			for x+w < len(Columns) && !covered[x+w][y] && c[x+w][y] == sig {
+				w++
+			}
+			h := 1
+			for ; y+h < Rows; h++ {
+				matches := true
+				for dx := range w {
+					if covered[x+dx][y+h] || c[x+dx][y+h] != sig {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					break
+				}
+			}
+			for dx := range w {
+				for dy := range h {
+					covered[x+dx][y+dy] = true
+				}
+			}
+			rect := fmt.Sprintf("%s%d", Columns[x], y+1)
+			if w > 1 || h > 1 {
+				rect += fmt.Sprintf("-%s%d", Columns[x+w-1], y+h)
+			}
+			ranges = append(ranges, numpadRanges(rect, sig)...)
+		}
+	}
+	return ranges
+}
+
+// numpadRanges returns the cell ranges of the rectangle of grids with the given sub numpads in each of their numpads.
+func numpadRanges(rect string, sig [Numpads]uint16) []string {
+	var masks []uint16
+	for _, m := range sig {
+		if m != 0 && !slices.Contains(masks, m) {
+			masks = append(masks, m)
+		}
+	}
+	// Whole numpads first, so that a grid which is entirely covered is just the grid
+	slices.SortStableFunc(masks, func(a, b uint16) int {
+		if a == FullNumpad {
+			return -1
+		}
+		if b == FullNumpad {
+			return 1
+		}
+		return 0
+	})
+	var ranges []string
+	for _, m := range masks {
+		var numpads []string
+		for n, s := range sig {
+			if s == m {
+				numpads = append(numpads, strconv.Itoa(n+1))
+			}
+		}
+		r := rect
+		if len(numpads) != Numpads {
+			r += ":" + strings.Join(numpads, ",")
+		}
+		if m != FullNumpad {
+			if len(numpads) == Numpads {
+				r += ":" + strings.Join(numpads, ",")
+			}
+			var subs []string
+			for s := range Numpads {
+				if m&(1<<s) != 0 {
+					subs = append(subs, strconv.Itoa(s+1))
+				}
+			}
+			r += ":" + strings.Join(subs, ",")
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// Equivalent returns an error describing the first difference of the fences of the configs, if they are not
+// equivalent. Configs are equivalent, when the fences of every server cover the same cells for every game state and
+// role. All game states are covered by the map names, game modes and player counts used in the conditions of both
+// configs, as all other values behave like one of them.
+func Equivalent(a, b *Config) error {
+	as, bs := a.EffectiveServers(), b.EffectiveServers()
+	if len(as) != len(bs) {
+		return fmt.Errorf("%d servers instead of %d", len(bs), len(as))
+	}
+	states := gameStates(append(slices.Clone(as), bs...))
+	for i := range as {
+		names := []string{""}
+		for name := range as[i].FenceSets {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			sa, _ := as[i].FenceSet(name)
+			sb, ok := bs[i].FenceSet(name)
+			if !ok {
+				return fmt.Errorf("server %d (%s): fence set %s is missing", i+1, as[i].Host, name)
+			}
+			lists := []struct {
+				name string
+				a, b []Fence
+			}{
+				{"AxisFence", sa.AxisFence, sb.AxisFence},
+				{"AlliesFence", sa.AlliesFence, sb.AlliesFence},
+				{"AxisDenyFence", sa.AxisDenyFence, sb.AxisDenyFence},
+				{"AlliesDenyFence", sa.AlliesDenyFence, sb.AlliesDenyFence},
+			}
+			for _, l := range lists {
+				if err := equivalentFences(l.a, l.b, states); err != nil {
+					if name != "" {
+						return fmt.Errorf("server %d (%s): fence set %s: %s: %w", i+1, as[i].Host, name, l.name, err)
+					}
+					return fmt.Errorf("server %d (%s): %s: %w", i+1, as[i].Host, l.name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// gameStates returns game states which behave like all game states for the conditions of the fences of the servers.
+func gameStates(servers []Server) []*api.GetSessionResponse {
+	mapNames, gameModes, playerCounts := []string{""}, []string{""}, []int{0}
+	add := func(fences []Fence) {
+		for _, f := range fences {
+			if f.Condition == nil {
+				continue
+			}
+			mapNames = append(mapNames, f.Condition.Equals["map_name"]...)
+			gameModes = append(gameModes, f.Condition.Equals["game_mode"]...)
+			for _, v := range f.Condition.LessThan {
+				playerCounts = append(playerCounts, v-1, v)
+			}
+			for _, v := range f.Condition.GreaterThan {
+				playerCounts = append(playerCounts, v, v+1)
+			}
+		}
+	}
+	for _, s := range servers {
+		sets := []FenceSet{{AxisFence: s.AxisFence, AlliesFence: s.AlliesFence, AxisDenyFence: s.AxisDenyFence, AlliesDenyFence: s.AlliesDenyFence}}
+		for _, set := range s.FenceSets {
+			sets = append(sets, set)
+		}
+		for _, set := range sets {
+			add(expandGroups(set.AxisFence))
+			add(expandGroups(set.AlliesFence))
+			add(expandGroups(set.AxisDenyFence))
+			add(expandGroups(set.AlliesDenyFence))
+		}
+	}
+	var states []*api.GetSessionResponse
+	for _, m := range slices.Compact(slices.Sorted(slices.Values(mapNames))) {
+		for _, g := range slices.Compact(slices.Sorted(slices.Values(gameModes))) {
+			for _, p := range slices.Compact(slices.Sorted(slices.Values(playerCounts))) {
+				states = append(states, &api.GetSessionResponse{MapName: m, GameMode: g, PlayerCount: p})
+			}
+		}
+	}
+	return states
+}
+
+// equivalentFences returns an error if the fences apply to different game states, or cover different cells for any
+// game state and role.
+func equivalentFences(a, b []Fence, states []*api.GetSessionResponse) error {
+	ca, cb := newCoverage(a), newCoverage(b)
+	for _, si := range states {
+		if ca.matches(si) != cb.matches(si) {
+			return fmt.Errorf("fences apply differently to %s %s with %d players", si.MapName, si.GameMode, si.PlayerCount)
+		}
+		for r := api.PlayerRole(0); r <= api.PlayerRoleArmyCommander; r++ {
+			ha, cellsA := ca.cells(si, r)
+			hb, cellsB := cb.cells(si, r)
+			if ha != hb || cellsA != cellsB {
+				return fmt.Errorf("fences cover different cells on %s %s with %d players for role %d", si.MapName, si.GameMode, si.PlayerCount, r)
+			}
+		}
+	}
+	return nil
+}
+
+// coverage caches the cells covered by fences, by the fences which apply.
+type coverage struct {
+	fences       []Fence
+	cellsOf      []CellSet
+	byApplicable map[string]*CellSet
+}
+
+func newCoverage(fences []Fence) *coverage {
+	c := &coverage{fences: fences, byApplicable: map[string]*CellSet{}}
+	for _, f := range fences {
+		c.cellsOf = append(c.cellsOf, f.Cells())
+	}
+	return c
+}
+
+func (c *coverage) matches(si *api.GetSessionResponse) bool {
+	return slices.ContainsFunc(c.fences, func(f Fence) bool { return f.Matches(si) })
+}
+
+// cells returns the cells covered by the fences which apply to the game state and the role, and whether any applies.
+func (c *coverage) cells(si *api.GetSessionResponse, r api.PlayerRole) (bool, CellSet) {
+	applicable := make([]byte, len(c.fences))
+	any := false
+	for i, f := range c.fences {
+		if f.Matches(si) && f.AppliesTo(r) {
+			applicable[i], any = 1, true
+		}
+	}
+	key := string(applicable)
+	cells, ok := c.byApplicable[key]
+	if !ok {
+		cells = &CellSet{}
+		for i := range c.fences {
+			if applicable[i] == 1 {
+				cells.Union(&c.cellsOf[i])
+			}
+		}
+		c.byApplicable[key] = cells
+	}
+	return any, *cells
+}
@@ -0,0 +1,166 @@
+package data_test
+
+import (
+	"os"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compact", func() {
+	It("merges fences with the same condition into ranges", func() {
+		c, _, err := loadConfig(`
+Servers:
+  - Host: 127.0.0.1
+    AxisFence:
+      - X: A
+      - X: B
+      - X: A
+        Y: 5
+      - X: J
+        Y: 10
+        Numpad: [1]
+        Condition: {LessThan: {player_count: 50}}
+      - X: J
+        Y: 10
+        Numpad: [2]
+        Condition: {LessThan: {player_count: 50}}
+      - X: E
+        Roles: [Sniper]
+`)
+		Expect(err).ToNot(HaveOccurred())
+
+		compact, err := data.Compact(c)
+		Expect(err).ToNot(HaveOccurred())
+
+		fences := compact.Servers[0].AxisFence
+		Expect(fences).To(HaveLen(3))
+		Expect(fences[0].CellRanges).To(Equal([]string{"A1-B10"}))
+		Expect(fences[1].CellRanges).To(Equal([]string{"J10:1,2"}))
+		Expect(fences[2].CellRanges).To(Equal([]string{"E1-E10"}))
+		Expect(fences[2].Roles).To(Equal([]string{"Sniper"}))
+	})
+
+	It("names conditions used by more than one fence", func() {
+		c, _, err := loadConfig(`
+Servers:
+  - Host: 127.0.0.1
+    AxisFence:
+      - X: A
+        Condition: {Equals: {map_name: [FOY, CARENTAN]}, LessThan: {player_count: 50}}
+    AlliesFence:
+      - X: J
+        Condition: {Equals: {map_name: [CARENTAN, FOY]}, LessThan: {player_count: 50}}
+`)
+		Expect(err).ToNot(HaveOccurred())
+
+		compact, err := data.Compact(c)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(compact.Conditions).To(HaveKey("carentan-and-1-more-below-50"))
+		Expect(compact.Servers[0].AxisFence[0].Matches(&api.GetSessionResponse{MapName: "FOY", PlayerCount: 10})).To(BeTrue())
+		Expect(compact.Servers[0].AlliesFence[0].Matches(&api.GetSessionResponse{MapName: "FOY", PlayerCount: 60})).To(BeFalse())
+	})
+
+	It("keeps the names of conditions", func() {
+		c, _, err := loadConfig(`
+Conditions:
+  seeding:
+    LessThan: {player_count: 50}
+Servers:
+  - Host: 127.0.0.1
+    AxisFence:
+      - X: A
+        Condition: seeding
+`)
+		Expect(err).ToNot(HaveOccurred())
+
+		compact, err := data.Compact(c)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(compact.Conditions).To(HaveKey("seeding"))
+	})
+
+	It("expands fence groups", func() {
+		c, _, err := loadConfig(`
+FenceGroups:
+  lastcap:
+    - X: A
+    - X: B
+Servers:
+  - Host: 127.0.0.1
+    AxisFence:
+      - Group: lastcap
+`)
+		Expect(err).ToNot(HaveOccurred())
+
+		compact, err := data.Compact(c)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(compact.FenceGroups).To(BeEmpty())
+		Expect(compact.Servers[0].AxisFence).To(HaveLen(1))
+		Expect(compact.Servers[0].AxisFence[0].Includes(api.Grid{X: "B", Y: 7, Numpad: 5})).To(BeTrue())
+	})
+
+	DescribeTable("compacts the seeding configs",
+		func(path string) {
+			b, err := os.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			c, _, err := loadConfig(string(b))
+			Expect(err).ToNot(HaveOccurred())
+
+			compact, err := data.Compact(c)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(len(compact.Servers[0].AxisFence)).To(BeNumerically("<", len(c.Servers[0].AxisFence)/10))
+			Expect(data.Equivalent(c, compact)).To(Succeed())
+		},
+		Entry("lastcap", "../seeding.lastcap.yml"),
+		Entry("midcap", "../seeding.midcap.yml"),
+	)
+})
+
+var _ = Describe("Equivalent", func() {
+	It("finds fences covering different cells", func() {
+		a, _, err := loadConfig("Servers: [{Host: a, AxisFence: [{X: A}]}]")
+		Expect(err).ToNot(HaveOccurred())
+		b, _, err := loadConfig("Servers: [{Host: a, AxisFence: [{X: A, Y: 1}]}]")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(data.Equivalent(a, b)).To(MatchError(ContainSubstring("AxisFence")))
+	})
+
+	It("finds fences applying to different game states", func() {
+		a, _, err := loadConfig("Servers: [{Host: a, AxisFence: [{X: A, Condition: {LessThan: {player_count: 50}}}]}]")
+		Expect(err).ToNot(HaveOccurred())
+		b, _, err := loadConfig("Servers: [{Host: a, AxisFence: [{X: A, Condition: {LessThan: {player_count: 51}}}]}]")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(data.Equivalent(a, b)).To(MatchError(ContainSubstring("50 players")))
+	})
+
+	It("finds fences applying to different roles", func() {
+		a, _, err := loadConfig("Servers: [{Host: a, AxisFence: [{X: A}]}]")
+		Expect(err).ToNot(HaveOccurred())
+		b, _, err := loadConfig("Servers: [{Host: a, AxisFence: [{X: A, ExceptRoles: [Sniper]}]}]")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(data.Equivalent(a, b)).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("CellSet", func() {
+	It("returns the cells as ranges", func() {
+		var s data.CellSet
+		for _, c := range []string{"A1-B2", "C1:5", "D1:5", "J10:1:3", "J10:2:3", "J10:4"} {
+			f := data.Fence{CellRanges: []string{c}}
+			cells := f.Cells()
+			s.Union(&cells)
+		}
+
+		Expect(s.Ranges()).To(Equal([]string{"A1-B2", "C1-D1:5", "J10:4", "J10:1,2:3"}))
+	})
+})
@@ -1,33 +1,299 @@
 package data
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/floriansw/go-hll-rcon/rconv2/api"
 	"gopkg.in/yaml.v3"
 )
 
 type Fence struct {
-	X         *string    `yaml:"X,omitempty"`
-	Y         *int       `yaml:"Y,omitempty"`
-	Numpads   []int      `yaml:"Numpad,omitempty"`
+	// X and Y are the column and row of the fence. In the config file, both can also be a range (C-H) or a list
+	// ([2, 3, 4] or 2,5-7), see columns and rows.
+	X       *string `yaml:"X,omitempty"`
+	Y       *int    `yaml:"Y,omitempty"`
+	Numpads []int   `yaml:"Numpad,omitempty"`
+	// SubNumpads limits the fence to the listed numpads within each of its numpads, e.g. X E, Y 5, Numpad 7 and
+	// SubNumpad 3 is the cell E5-7-3.
+	SubNumpads []int `yaml:"SubNumpad,omitempty"`
+	// CellRanges is a short form of a list of fences, e.g. D3-G7 for all grids from D3 to G7 or B5:6 for B5 Numpad 6.
+	// It cannot be combined with X, Y, Numpad or SubNumpad.
+	CellRanges []string `yaml:"Cells,omitempty"`
+	// Group refers to one of the FenceGroups of the config and stands for all fences of the group. Condition, Roles
+	// and ExceptRoles of the reference apply to the fences of the group which do not have their own.
+	Group string `yaml:"Group,omitempty"`
+	// Condition limits the fence to matching game states. In the config file, it can also be the name of one of the
+	// Conditions of the config.
 	Condition *Condition `yaml:"Condition,omitempty"`
+	// Roles limits the fence to players with one of the listed roles, ExceptRoles excludes the listed roles from it.
+	Roles       []string `yaml:"Roles,omitempty"`
+	ExceptRoles []string `yaml:"ExceptRoles,omitempty"`
+
+	// columns and rows are the columns and rows of the fence when X or Y were a range or a list in the config file,
+	// which are kept in rawX and rawY to save them as they were.
+	columns    []string
+	rows       []int
+	rawX, rawY *yaml.Node
+	// conditionName is the name of the condition the fence refers to, and group the fences of the referred group,
+	// see Config.resolve.
+	conditionName string
+	group         []Fence
+}
+
+// fenceYAML is the form of a fence in the config file.
+type fenceYAML struct {
+	X           yaml.Node `yaml:"X,omitempty"`
+	Y           yaml.Node `yaml:"Y,omitempty"`
+	Numpads     []int     `yaml:"Numpad,omitempty"`
+	SubNumpads  []int     `yaml:"SubNumpad,omitempty"`
+	CellRanges  []string  `yaml:"Cells,omitempty"`
+	Group       string    `yaml:"Group,omitempty"`
+	Condition   yaml.Node `yaml:"Condition,omitempty"`
+	Roles       []string  `yaml:"Roles,omitempty"`
+	ExceptRoles []string  `yaml:"ExceptRoles,omitempty"`
+}
+
+func (f *Fence) UnmarshalYAML(n *yaml.Node) error {
+	var v fenceYAML
+	if err := n.Decode(&v); err != nil {
+		return err
+	}
+	*f = Fence{
+		Numpads:     v.Numpads,
+		SubNumpads:  v.SubNumpads,
+		CellRanges:  v.CellRanges,
+		Group:       v.Group,
+		Roles:       v.Roles,
+		ExceptRoles: v.ExceptRoles,
+	}
+	if v.Condition.Kind == yaml.ScalarNode {
+		f.conditionName = v.Condition.Value
+	} else if v.Condition.Kind != 0 {
+		f.Condition = &Condition{}
+		if err := v.Condition.Decode(f.Condition); err != nil {
+			return err
+		}
+	}
+	if f.Group != "" && (v.X.Kind != 0 || v.Y.Kind != 0 || len(f.Numpads) != 0 || len(f.SubNumpads) != 0 || len(f.CellRanges) != 0) {
+		return fmt.Errorf("line %d: fence with Group cannot have X, Y, Numpad, SubNumpad or Cells", n.Line)
+	}
+	if len(f.CellRanges) != 0 && (v.X.Kind != 0 || v.Y.Kind != 0 || len(f.Numpads) != 0 || len(f.SubNumpads) != 0) {
+		return fmt.Errorf("line %d: fence with Cells cannot have X, Y, Numpad or SubNumpad", n.Line)
+	}
+	for _, c := range f.CellRanges {
+		if _, err := parseCellRange(c); err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+	}
+	if v.X.Kind != 0 {
+		columns, err := parseNode(&v.X, parseColumns)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid X: %w", v.X.Line, err)
+		}
+		if v.X.Kind == yaml.ScalarNode && len(columns) == 1 && !strings.ContainsAny(v.X.Value, ",-") {
+			f.X = &v.X.Value
+		} else {
+			f.columns, f.rawX = columns, &v.X
+		}
+	}
+	if v.Y.Kind != 0 {
+		rows, err := parseNode(&v.Y, parseRows)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid Y: %w", v.Y.Line, err)
+		}
+		if v.Y.Kind == yaml.ScalarNode && len(rows) == 1 && !strings.ContainsAny(v.Y.Value, ",-") {
+			f.Y = &rows[0]
+		} else {
+			f.rows, f.rawY = rows, &v.Y
+		}
+	}
+	return nil
+}
+
+// parseNode parses a scalar or every item of a sequence with parse.
+func parseNode[T any](n *yaml.Node, parse func(string) ([]T, error)) ([]T, error) {
+	if n.Kind == yaml.ScalarNode {
+		return parse(n.Value)
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, errors.New("expected a value or a list")
+	}
+	var values []T
+	for _, item := range n.Content {
+		if item.Kind != yaml.ScalarNode {
+			return nil, errors.New("expected a list of values")
+		}
+		v, err := parse(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v...)
+	}
+	return values, nil
 }
 
+func (f Fence) MarshalYAML() (interface{}, error) {
+	v := fenceYAML{
+		Numpads:     f.Numpads,
+		SubNumpads:  f.SubNumpads,
+		CellRanges:  f.CellRanges,
+		Group:       f.Group,
+		Roles:       f.Roles,
+		ExceptRoles: f.ExceptRoles,
+	}
+	if f.conditionName != "" {
+		if err := v.Condition.Encode(f.conditionName); err != nil {
+			return nil, err
+		}
+	} else if f.Condition != nil {
+		if err := v.Condition.Encode(f.Condition); err != nil {
+			return nil, err
+		}
+	}
+	if f.rawX != nil {
+		v.X = *f.rawX
+	} else if f.X != nil {
+		if err := v.X.Encode(*f.X); err != nil {
+			return nil, err
+		}
+	}
+	if f.rawY != nil {
+		v.Y = *f.rawY
+	} else if f.Y != nil {
+		if err := v.Y.Encode(*f.Y); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// hasColumn reports whether the fence includes grids of the column.
+func (f Fence) hasColumn(x string) bool {
+	if f.columns != nil {
+		return slices.Contains(f.columns, x)
+	}
+	return f.X == nil || *f.X == x
+}
+
+// hasRow reports whether the fence includes grids of the row.
+func (f Fence) hasRow(y int) bool {
+	if f.rows != nil {
+		return slices.Contains(f.rows, y)
+	}
+	return f.Y == nil || *f.Y == y
+}
+
+// cellRanges returns the areas of CellRanges as fences. Invalid cell ranges are rejected when loading the config and
+// ignored here.
+func (f Fence) cellRanges() []Fence {
+	fences := make([]Fence, 0, len(f.CellRanges))
+	for _, c := range f.CellRanges {
+		if r, err := parseCellRange(c); err == nil {
+			fences = append(fences, r)
+		}
+	}
+	return fences
+}
+
+// covers returns the sub numpads of the numpad of the grid included in the fence, see Area.
+func (f Fence) covers(w api.Grid) uint16 {
+	if len(f.CellRanges) != 0 {
+		var m uint16
+		for _, r := range f.cellRanges() {
+			m |= r.covers(w)
+		}
+		return m
+	}
+	if !f.hasColumn(w.X) || !f.hasRow(w.Y) {
+		return 0
+	}
+	if len(f.Numpads) != 0 && !slices.Contains(f.Numpads, w.Numpad) {
+		return 0
+	}
+	return mask(f.SubNumpads)
+}
+
+// Includes reports whether the fence includes the numpad of the grid, or a part of it when the fence has sub
+// numpads.
 func (f Fence) Includes(w api.Grid) bool {
-	if f.X != nil && w.X != *f.X {
+	return f.covers(w) != 0
+}
+
+// IncludesCell reports whether the fence includes the cell. A cell without a sub numpad is included when the fence
+// includes the whole numpad.
+func (f Fence) IncludesCell(c Cell) bool {
+	m := c.mask()
+	return m != 0 && f.covers(c.Grid)&m == m
+}
+
+// AppliesTo reports whether the fence is relevant for a player with the given role.
+func (f Fence) AppliesTo(r api.PlayerRole) bool {
+	if len(f.Roles) != 0 && !containsRole(f.Roles, r) {
 		return false
 	}
-	if f.Y != nil && w.Y != *f.Y {
-		return false
+	return !containsRole(f.ExceptRoles, r)
+}
+
+func (f Fence) String() string {
+	if len(f.CellRanges) != 0 {
+		return strings.Join(f.CellRanges, " ")
 	}
-	if len(f.Numpads) == 0 {
-		return true
+	var x, y string
+	if f.columns != nil {
+		x = formatSpan(columnIndexes(f.columns), func(i int) string { return Columns[i] })
+	} else if f.X != nil {
+		x = *f.X
+	}
+	if f.rows != nil {
+		y = formatSpan(f.rows, strconv.Itoa)
+	} else if f.Y != nil {
+		y = strconv.Itoa(*f.Y)
+	}
+	s := x + y
+	if f.columns != nil || f.rows != nil {
+		s = strings.TrimSpace(x + " " + y)
+	}
+	if s == "" {
+		s = "everywhere"
+	}
+	if len(f.Numpads) != 0 {
+		n := make([]string, len(f.Numpads))
+		for i, numpad := range f.Numpads {
+			n[i] = strconv.Itoa(numpad)
+		}
+		s += " Numpad " + strings.Join(n, ",")
+	}
+	if len(f.SubNumpads) != 0 {
+		n := make([]string, len(f.SubNumpads))
+		for i, numpad := range f.SubNumpads {
+			n[i] = strconv.Itoa(numpad)
+		}
+		s += " Sub " + strings.Join(n, ",")
+	}
+	return s
+}
+
+// DescribeFences returns a short, human-readable summary of the area covered by the fences, e.g. for messages to
+// players.
+func DescribeFences(fences []Fence) string {
+	var v []string
+	for _, f := range fences {
+		if s := f.String(); !slices.Contains(v, s) {
+			v = append(v, s)
+		}
+	}
+	if len(v) > 6 {
+		return fmt.Sprintf("%s and %d more", strings.Join(v[:6], ", "), len(v)-6)
 	}
-	return slices.Contains(f.Numpads, w.Numpad)
+	return strings.Join(v, ", ")
 }
 
 func (f Fence) Matches(si *api.GetSessionResponse) bool {
@@ -72,13 +338,478 @@ func (c Condition) Matches(si *api.GetSessionResponse) bool {
 }
 
 type Server struct {
-	Host               string    `yaml:"Host"`
-	Port               int       `yaml:"Port"`
-	Password           string    `yaml:"Password"`
-	PunishAfterSeconds *int      `yaml:"PunishAfterSeconds,omitempty"`
-	AxisFence          []Fence   `yaml:"AxisFence"`
-	AlliesFence        []Fence   `yaml:"AlliesFence"`
-	Messages           *Messages `yaml:"Messages,omitempty"`
+	// Group is the name of one of the ServerGroups of the config the server inherits settings from, see Config.
+	Group              string `yaml:"Group,omitempty"`
+	Host               string `yaml:"Host,omitempty"`
+	Port               int    `yaml:"Port,omitempty"`
+	Password           string `yaml:"Password,omitempty"`
+	PunishAfterSeconds *int   `yaml:"PunishAfterSeconds,omitempty"`
+	// RepeatPunishSeconds repeats the punishment of a player in this interval for as long as the player stays outside.
+	RepeatPunishSeconds *int    `yaml:"RepeatPunishSeconds,omitempty"`
+	AxisFence           []Fence `yaml:"AxisFence"`
+	AlliesFence         []Fence `yaml:"AlliesFence"`
+	// AxisDenyFence and AlliesDenyFence describe areas a player is not allowed to enter, even if they are inside an
+	// allowed fence.
+	AxisDenyFence   []Fence             `yaml:"AxisDenyFence,omitempty"`
+	AlliesDenyFence []Fence             `yaml:"AlliesDenyFence,omitempty"`
+	Roles           map[string]RoleRule `yaml:"Roles,omitempty"`
+	Whitelist       *Whitelist          `yaml:"Whitelist,omitempty"`
+	// FenceSets are named alternatives to the fences of the server, which can be activated with admin commands.
+	FenceSets map[string]FenceSet `yaml:"FenceSets,omitempty"`
+	Commands  *Commands           `yaml:"Commands,omitempty"`
+	Grace     *Grace              `yaml:"Grace,omitempty"`
+	// OutsideSpawn configures how players are handled that are outside the fences without having been inside before,
+	// e.g. because they spawned at an HQ or garrison outside the fences.
+	OutsideSpawn *OutsideSpawn `yaml:"OutsideSpawn,omitempty"`
+	Enforcement  *Enforcement  `yaml:"Enforcement,omitempty"`
+	PreWarning   *PreWarning   `yaml:"PreWarning,omitempty"`
+	Vehicles     *Vehicles     `yaml:"Vehicles,omitempty"`
+	Positions    *Positions    `yaml:"Positions,omitempty"`
+	Buffer       *Buffer       `yaml:"Buffer,omitempty"`
+	Connection   *Connection   `yaml:"Connection,omitempty"`
+	Polling      *Polling      `yaml:"Polling,omitempty"`
+	Messages     *Messages     `yaml:"Messages,omitempty"`
+}
+
+// Connection configures the RCON connection pool of the server. Unset values use the defaults of the pool.
+type Connection struct {
+	MaxOpenConnections *int `yaml:"MaxOpenConnections,omitempty"`
+	MaxIdleConnections *int `yaml:"MaxIdleConnections,omitempty"`
+}
+
+// Polling configures how often the server is polled. The player poll interval adapts to the state of the server.
+type Polling struct {
+	// SessionMilliseconds is the interval of session polls. Defaults to 1000.
+	SessionMilliseconds *int `yaml:"SessionMilliseconds,omitempty"`
+	// PlayersMilliseconds is the interval of player polls. Defaults to 500.
+	PlayersMilliseconds *int `yaml:"PlayersMilliseconds,omitempty"`
+	// IdleMilliseconds is the interval of session and player polls while the server is empty, no fences apply or
+	// the fences are disabled. Defaults to 5000.
+	IdleMilliseconds *int `yaml:"IdleMilliseconds,omitempty"`
+	// BoundaryMilliseconds is the interval of player polls while any player is outside or next to the boundary of
+	// the fences. Defaults to PlayersMilliseconds.
+	BoundaryMilliseconds *int `yaml:"BoundaryMilliseconds,omitempty"`
+	// AdminLogMilliseconds is the interval of admin log polls. Defaults to 2000.
+	AdminLogMilliseconds *int `yaml:"AdminLogMilliseconds,omitempty"`
+	// InactivityRestartMinutes is the time without a map change after which the application restarts, if the
+	// server is empty. Defaults to 120.
+	InactivityRestartMinutes *int `yaml:"InactivityRestartMinutes,omitempty"`
+}
+
+func (s Server) MaxOpenConnections() *int {
+	if s.Connection == nil {
+		return nil
+	}
+	return s.Connection.MaxOpenConnections
+}
+
+// MaxIdleConnections defaults to MaxOpenConnections, if that is lower than the default of the pool.
+func (s Server) MaxIdleConnections() *int {
+	if s.Connection == nil {
+		return nil
+	}
+	if s.Connection.MaxIdleConnections == nil && s.Connection.MaxOpenConnections != nil && *s.Connection.MaxOpenConnections < 10 {
+		return s.Connection.MaxOpenConnections
+	}
+	return s.Connection.MaxIdleConnections
+}
+
+func (s Server) polling() Polling {
+	if s.Polling == nil {
+		return Polling{}
+	}
+	return *s.Polling
+}
+
+func (s Server) SessionInterval() time.Duration {
+	return milliseconds(s.polling().SessionMilliseconds, time.Second)
+}
+
+func (s Server) PlayersInterval() time.Duration {
+	return milliseconds(s.polling().PlayersMilliseconds, 500*time.Millisecond)
+}
+
+func (s Server) IdleInterval() time.Duration {
+	return milliseconds(s.polling().IdleMilliseconds, 5*time.Second)
+}
+
+func (s Server) BoundaryInterval() time.Duration {
+	return milliseconds(s.polling().BoundaryMilliseconds, s.PlayersInterval())
+}
+
+func (s Server) AdminLogInterval() time.Duration {
+	return milliseconds(s.polling().AdminLogMilliseconds, 2*time.Second)
+}
+
+func (s Server) InactivityRestart() time.Duration {
+	if v := s.polling().InactivityRestartMinutes; v != nil && *v > 0 {
+		return time.Duration(*v) * time.Minute
+	}
+	return 2 * time.Hour
+}
+
+// milliseconds returns the configured interval, or the default if it is not set or not positive.
+func milliseconds(v *int, def time.Duration) time.Duration {
+	if v == nil || *v <= 0 {
+		return def
+	}
+	return time.Duration(*v) * time.Millisecond
+}
+
+// Grace configures periods in which the time a player is out-of-bounds is not counted.
+type Grace struct {
+	// AfterSpawnSeconds starts when a player spawns or redeploys.
+	AfterSpawnSeconds *int `yaml:"AfterSpawnSeconds,omitempty"`
+	// AfterMatchStartSeconds starts when a new match starts.
+	AfterMatchStartSeconds *int `yaml:"AfterMatchStartSeconds,omitempty"`
+	// AfterFenceChangeSeconds starts when the fences applicable to the current game state change, e.g. when the
+	// player count crosses a threshold of a condition.
+	AfterFenceChangeSeconds *int `yaml:"AfterFenceChangeSeconds,omitempty"`
+}
+
+func (s Server) SpawnGrace() time.Duration {
+	if s.Grace == nil {
+		return 0
+	}
+	return seconds(s.Grace.AfterSpawnSeconds)
+}
+
+func (s Server) MatchStartGrace() time.Duration {
+	if s.Grace == nil {
+		return 0
+	}
+	return seconds(s.Grace.AfterMatchStartSeconds)
+}
+
+func (s Server) FenceChangeGrace() time.Duration {
+	if s.Grace == nil {
+		return 0
+	}
+	return seconds(s.Grace.AfterFenceChangeSeconds)
+}
+
+const (
+	// OutsideSpawnIgnore ignores players until they entered the fences once.
+	OutsideSpawnIgnore = "ignore"
+	// OutsideSpawnGrace gives players some time to reach the fences before they get punished.
+	OutsideSpawnGrace = "grace"
+	// OutsideSpawnEnforce handles players like any other player outside the fences.
+	OutsideSpawnEnforce = "enforce"
+)
+
+type OutsideSpawn struct {
+	// Policy is one of ignore (default), grace or enforce.
+	Policy string `yaml:"Policy,omitempty"`
+	// Seconds is the time players have to reach the fences with the grace policy. Defaults to 60 seconds.
+	Seconds *int `yaml:"Seconds,omitempty"`
+	// Message is sent to players outside the fences with the grace policy. {area} is replaced with a summary of the
+	// allowed area, the placeholders of warnings are supported as well.
+	Message *string `yaml:"Message,omitempty"`
+}
+
+func (s Server) OutsideSpawnPolicy() string {
+	if s.OutsideSpawn == nil || s.OutsideSpawn.Policy == "" {
+		return OutsideSpawnIgnore
+	}
+	return strings.ToLower(s.OutsideSpawn.Policy)
+}
+
+func (s Server) OutsideSpawnTime() time.Duration {
+	if s.OutsideSpawn == nil || s.OutsideSpawn.Seconds == nil {
+		return time.Minute
+	}
+	return seconds(s.OutsideSpawn.Seconds)
+}
+
+func (s Server) OutsideSpawnMessage(area string) string {
+	message := "You are outside of the designated play area! Please move to {area}.\n\nYou will be punished in {seconds} seconds."
+	if s.OutsideSpawn != nil && s.OutsideSpawn.Message != nil {
+		message = *s.OutsideSpawn.Message
+	}
+	return strings.NewReplacer(
+		"{area}", area,
+		"{seconds}", strconv.Itoa(int(s.OutsideSpawnTime().Seconds())),
+	).Replace(message)
+}
+
+const (
+	// EnforcementContinuous punishes players who are continuously out-of-bounds for PunishAfterSeconds.
+	EnforcementContinuous = "continuous"
+	// EnforcementBudget punishes players once the accumulated out-of-bounds time of the match exceeds a budget.
+	EnforcementBudget = "budget"
+)
+
+type Enforcement struct {
+	// Model is either continuous (default) or budget.
+	Model string `yaml:"Model,omitempty"`
+	// BudgetSeconds is the out-of-bounds time a player can accumulate per match. Defaults to the punish delay of the
+	// server (or role).
+	BudgetSeconds *int `yaml:"BudgetSeconds,omitempty"`
+	// WarnAtSeconds is the used budget at which a player is warned. Defaults to 0, warning players when they leave the
+	// fences for the first time.
+	WarnAtSeconds *int `yaml:"WarnAtSeconds,omitempty"`
+	// DecaySecondsPerMinute is the budget restored for every minute a player is inside the fences. Defaults to 0.
+	DecaySecondsPerMinute *int `yaml:"DecaySecondsPerMinute,omitempty"`
+}
+
+func (s Server) EnforcementModel() string {
+	if s.Enforcement == nil || s.Enforcement.Model == "" {
+		return EnforcementContinuous
+	}
+	return strings.ToLower(s.Enforcement.Model)
+}
+
+// Budget returns the out-of-bounds time a player with the given role can accumulate per match with the budget model.
+func (s Server) Budget(r api.PlayerRole) time.Duration {
+	if s.Enforcement == nil || s.Enforcement.BudgetSeconds == nil {
+		return s.PunishAfter(r)
+	}
+	return seconds(s.Enforcement.BudgetSeconds)
+}
+
+func (s Server) BudgetWarnAt() time.Duration {
+	if s.Enforcement == nil {
+		return 0
+	}
+	return seconds(s.Enforcement.WarnAtSeconds)
+}
+
+// PreWarning warns players inside the fences who move towards the boundary of the fences and get close to it.
+type PreWarning struct {
+	// DistanceMeters is the distance to the boundary at which players are warned.
+	DistanceMeters *int `yaml:"DistanceMeters,omitempty"`
+	// Message is sent to the player. {distance} is replaced with the distance to the boundary and {direction} with the
+	// compass direction of it.
+	Message *string `yaml:"Message,omitempty"`
+}
+
+// PreWarningDistance returns the distance to the boundary in metres at which players are warned, or 0 if they are
+// not.
+func (s Server) PreWarningDistance() float64 {
+	if s.PreWarning == nil || s.PreWarning.DistanceMeters == nil || *s.PreWarning.DistanceMeters < 0 {
+		return 0
+	}
+	return float64(*s.PreWarning.DistanceMeters)
+}
+
+func (s Server) PreWarningMessage() string {
+	if s.PreWarning == nil || s.PreWarning.Message == nil {
+		return "You are {distance} away from the edge of the play area ({direction}). Turn around!"
+	}
+	return *s.PreWarning.Message
+}
+
+// Vehicles configures the enforcement for players in vehicles. A player is considered to be in a vehicle when they
+// move faster than SpeedMetersPerSecond or have one of the Roles.
+type Vehicles struct {
+	// SpeedMetersPerSecond is the speed above which a player is considered to be in a vehicle. Defaults to 0, only
+	// considering the Roles.
+	SpeedMetersPerSecond *float64 `yaml:"SpeedMetersPerSecond,omitempty"`
+	// Roles are the roles considered to be in a vehicle. Defaults to Crewman and TankCommander.
+	Roles []string `yaml:"Roles,omitempty"`
+	// PunishAfterSeconds overrides the punish delay of the server and role. Defaults to the punish delay of the role.
+	PunishAfterSeconds *int `yaml:"PunishAfterSeconds,omitempty"`
+	// BufferMeters is the distance players in vehicles can be outside the fences without being considered outside,
+	// e.g. when cutting a corner. Defaults to 0.
+	BufferMeters *float64 `yaml:"BufferMeters,omitempty"`
+}
+
+// Positions configures the filtering of implausible player positions, e.g. when the position of a player jumps
+// because of a server hitch.
+type Positions struct {
+	// MaxSpeedMetersPerSecond is the speed above which the movement of a player between two polls is considered
+	// impossible. Such positions are discarded, unless the player spawned in between. Defaults to 60.
+	MaxSpeedMetersPerSecond *float64 `yaml:"MaxSpeedMetersPerSecond,omitempty"`
+	// OutsideSamples is the number of consecutive polls a player needs to be outside the fences before their
+	// out-of-bounds period starts. Defaults to 1.
+	OutsideSamples *int `yaml:"OutsideSamples,omitempty"`
+}
+
+// Buffer configures margins around the boundary of the fences, so that players moving along the boundary do not
+// flicker between inside and outside.
+type Buffer struct {
+	// ToleranceMeters is the distance players can be outside the fences before their out-of-bounds period starts.
+	ToleranceMeters *float64 `yaml:"ToleranceMeters,omitempty"`
+	// ReturnMeters is the distance players need to come back into the fences to end their out-of-bounds period.
+	ReturnMeters *float64 `yaml:"ReturnMeters,omitempty"`
+}
+
+// BoundaryTolerance returns the distance in metres players can be outside the fences before their out-of-bounds
+// period starts.
+func (s Server) BoundaryTolerance() float64 {
+	if s.Buffer == nil {
+		return 0
+	}
+	return meters(s.Buffer.ToleranceMeters)
+}
+
+// ReturnMargin returns the distance in metres players need to come back into the fences to end their out-of-bounds
+// period.
+func (s Server) ReturnMargin() float64 {
+	if s.Buffer == nil {
+		return 0
+	}
+	return meters(s.Buffer.ReturnMeters)
+}
+
+func (s Server) MaxPlausibleSpeed() float64 {
+	if s.Positions == nil || s.Positions.MaxSpeedMetersPerSecond == nil || *s.Positions.MaxSpeedMetersPerSecond <= 0 {
+		return 60
+	}
+	return *s.Positions.MaxSpeedMetersPerSecond
+}
+
+func (s Server) OutsideSamples() int {
+	if s.Positions == nil || s.Positions.OutsideSamples == nil || *s.Positions.OutsideSamples < 1 {
+		return 1
+	}
+	return *s.Positions.OutsideSamples
+}
+
+var defaultVehicleRoles = []string{"Crewman", "TankCommander"}
+
+// InVehicle reports whether a player with the given role moving at the given speed in metres per second is
+// considered to be in a vehicle.
+func (s Server) InVehicle(r api.PlayerRole, speed float64) bool {
+	if s.Vehicles == nil {
+		return false
+	}
+	if limit := meters(s.Vehicles.SpeedMetersPerSecond); limit > 0 && speed > limit {
+		return true
+	}
+	names := s.Vehicles.Roles
+	if names == nil {
+		names = defaultVehicleRoles
+	}
+	return containsRole(names, r)
+}
+
+// VehiclePunishAfter returns the time a player with the given role in a vehicle can be out-of-bounds before getting
+// punished.
+func (s Server) VehiclePunishAfter(r api.PlayerRole) time.Duration {
+	if s.Vehicles == nil || s.Vehicles.PunishAfterSeconds == nil {
+		return s.PunishAfter(r)
+	}
+	return seconds(s.Vehicles.PunishAfterSeconds)
+}
+
+// VehicleBuffer returns the distance in metres players in vehicles can be outside the fences.
+func (s Server) VehicleBuffer() float64 {
+	if s.Vehicles == nil {
+		return 0
+	}
+	return meters(s.Vehicles.BufferMeters)
+}
+
+// BudgetDecay returns the budget restored after the player was inside the fences for the given time.
+func (s Server) BudgetDecay(inside time.Duration) time.Duration {
+	if s.Enforcement == nil || s.Enforcement.DecaySecondsPerMinute == nil {
+		return 0
+	}
+	return time.Duration(float64(seconds(s.Enforcement.DecaySecondsPerMinute)) * inside.Minutes())
+}
+
+// meters returns the distance or speed, or 0 if it is not set or negative.
+func meters(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return max(*v, 0)
+}
+
+func seconds(v *int) time.Duration {
+	if v == nil {
+		return 0
+	}
+	return time.Duration(*v) * time.Second
+}
+
+type FenceSet struct {
+	AxisFence       []Fence `yaml:"AxisFence"`
+	AlliesFence     []Fence `yaml:"AlliesFence"`
+	AxisDenyFence   []Fence `yaml:"AxisDenyFence,omitempty"`
+	AlliesDenyFence []Fence `yaml:"AlliesDenyFence,omitempty"`
+}
+
+// FenceSet returns the fence set with the given name. An empty name refers to the fences configured directly on the
+// server.
+func (s Server) FenceSet(name string) (FenceSet, bool) {
+	if name == "" {
+		return FenceSet{
+			AxisFence:       expandGroups(s.AxisFence),
+			AlliesFence:     expandGroups(s.AlliesFence),
+			AxisDenyFence:   expandGroups(s.AxisDenyFence),
+			AlliesDenyFence: expandGroups(s.AlliesDenyFence),
+		}, true
+	}
+	for n, set := range s.FenceSets {
+		if strings.EqualFold(n, name) {
+			return FenceSet{
+				AxisFence:       expandGroups(set.AxisFence),
+				AlliesFence:     expandGroups(set.AlliesFence),
+				AxisDenyFence:   expandGroups(set.AxisDenyFence),
+				AlliesDenyFence: expandGroups(set.AlliesDenyFence),
+			}, true
+		}
+	}
+	return FenceSet{}, false
+}
+
+type Commands struct {
+	// Admins is the list of player IDs allowed to use admin chat commands.
+	Admins []string `yaml:"Admins"`
+	// CooldownSeconds is the minimum time between two commands of the same admin. Defaults to 2 seconds.
+	CooldownSeconds *int `yaml:"CooldownSeconds,omitempty"`
+}
+
+func (c Commands) IsAdmin(playerId string) bool {
+	return slices.Contains(c.Admins, playerId)
+}
+
+func (c Commands) Cooldown() time.Duration {
+	if c.CooldownSeconds == nil {
+		return 2 * time.Second
+	}
+	return time.Duration(*c.CooldownSeconds) * time.Second
+}
+
+type RoleRule struct {
+	// Exempt excludes players with this role from any fence.
+	Exempt             bool `yaml:"Exempt,omitempty"`
+	PunishAfterSeconds *int `yaml:"PunishAfterSeconds,omitempty"`
+}
+
+// RepeatPunishAfter returns the interval in which punishments are repeated, or 0 if they are not.
+func (s Server) RepeatPunishAfter() time.Duration {
+	return seconds(s.RepeatPunishSeconds)
+}
+
+// RoleRule returns the configured rule for the given role, if any.
+func (s Server) RoleRule(r api.PlayerRole) (RoleRule, bool) {
+	for _, name := range slices.Sorted(maps.Keys(s.Roles)) {
+		if role, ok := ParseRole(name); ok && role == r {
+			return s.Roles[name], true
+		}
+	}
+	return RoleRule{}, false
+}
+
+// IsExemptRole reports whether players with the given role are not subject to any fence.
+func (s Server) IsExemptRole(r api.PlayerRole) bool {
+	rule, ok := s.RoleRule(r)
+	return ok && rule.Exempt
+}
+
+// PunishAfter returns the time a player with the given role can be out-of-bounds before getting punished.
+func (s Server) PunishAfter(r api.PlayerRole) time.Duration {
+	punishAfterSeconds := 10
+	if s.PunishAfterSeconds != nil {
+		punishAfterSeconds = *s.PunishAfterSeconds
+	}
+	if rule, ok := s.RoleRule(r); ok && rule.PunishAfterSeconds != nil {
+		punishAfterSeconds = *rule.PunishAfterSeconds
+	}
+	return time.Duration(punishAfterSeconds) * time.Second
 }
 
 func (s Server) PunishMessage() string {
@@ -108,22 +839,78 @@ func (s Server) GetWhitelist() []string {
 	return nil
 }
 
+// WhitelistEntry returns the first entry of the server whitelist (or the additional entries passed in, e.g. from a
+// whitelist file) matching the player. The global WHITELIST environment variable is considered as well.
+func (s Server) WhitelistEntry(p api.GetPlayerResponse, now time.Time, additional ...WhitelistEntry) (WhitelistEntry, bool) {
+	if slices.Contains(s.GetWhitelist(), p.Id) {
+		return WhitelistEntry{PlayerId: p.Id, Reason: "WHITELIST environment variable"}, true
+	}
+	var entries []WhitelistEntry
+	if s.Whitelist != nil {
+		entries = s.Whitelist.Entries
+	}
+	for _, e := range append(slices.Clip(entries), additional...) {
+		if e.Matches(p, now) {
+			return e, true
+		}
+	}
+	return WhitelistEntry{}, false
+}
+
+// Messages sent to players. In warnings, {grid} is replaced with the grid of the player, {distance} with the distance
+// to the nearest allowed area (e.g. 80m) and {direction} with the compass direction to it (e.g. north-east).
 type Messages struct {
 	Warning *string `yaml:"Warning,omitempty"`
 	Punish  *string `yaml:"Punish,omitempty"`
 }
 
 type Config struct {
-	Servers []Server `yaml:"Servers"`
-	path    string
+	// Conditions and FenceGroups are named conditions and lists of fences, which fences of all servers can refer to
+	// by their name.
+	Conditions  map[string]Condition `yaml:"Conditions,omitempty"`
+	FenceGroups map[string][]Fence   `yaml:"FenceGroups,omitempty"`
+	// Defaults are settings inherited by all servers, and ServerGroups settings inherited by the servers referring to
+	// them with Group. Settings of a server override the ones of its group, which override the defaults.
+	Defaults     *Server           `yaml:"Defaults,omitempty"`
+	ServerGroups map[string]Server `yaml:"ServerGroups,omitempty"`
+	Servers      []Server          `yaml:"Servers"`
+	path         string
+	// readOnly is set for configs read from a directory or from a file which is not YAML, which are not saved.
+	readOnly bool
+	// effective are the servers with their inherited settings, see EffectiveServers.
+	effective []Server
+}
+
+// EffectiveServers returns the servers of the config with the settings they inherit from their server group and the
+// defaults.
+func (c *Config) EffectiveServers() []Server {
+	if c.effective == nil {
+		// Servers of a config not loaded from a file
+		return c.Servers
+	}
+	return c.effective
 }
 
+// Save writes the config back to its file, unless it was read from a directory or a JSON or TOML file.
 func (c *Config) Save() error {
+	if c.readOnly {
+		return nil
+	}
+	return c.SaveTo(c.path)
+}
+
+// SaveTo writes the config as YAML to the file at the path.
+func (c *Config) SaveTo(path string) error {
 	config, err := yaml.Marshal(c)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(c.path, config, 0655)
+	return os.WriteFile(path, config, 0655)
+}
+
+// ReadOnly reports whether Save keeps the config as it is, as it was read from a directory or a JSON or TOML file.
+func (c *Config) ReadOnly() bool {
+	return c.readOnly
 }
 
 func NewConfig(path string, logger *slog.Logger) (*Config, error) {
@@ -135,22 +922,48 @@ func NewConfig(path string, logger *slog.Logger) (*Config, error) {
 	return config, config.Save()
 }
 
+// LoadConfig reads the config like NewConfig, but does not save it, so that the file keeps its comments and
+// formatting.
+func LoadConfig(path string, logger *slog.Logger) (*Config, error) {
+	return readConfig(path, logger)
+}
+
+// readConfig reads the config file at the path, or all config files in the directory at the path, see readConfigDir.
 func readConfig(path string, logger *slog.Logger) (*Config, error) {
 	var config Config
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if info, err := os.Stat(path); os.IsNotExist(err) {
 		logger.Info("create-config")
 		config = Config{}
 	} else {
-		logger.Info("read-existing-config")
-		c, err := os.ReadFile(path)
+		if info != nil && info.IsDir() {
+			logger.Info("read-existing-config-dir")
+			config, err = readConfigDir(path)
+			config.readOnly = true
+		} else {
+			logger.Info("read-existing-config")
+			var c []byte
+			if c, err = os.ReadFile(path); err == nil {
+				config, err = decodeConfig(path, c)
+			}
+			config.readOnly = !isYAML(path)
+		}
 		if err != nil {
 			return &Config{}, err
 		}
-		err = yaml.Unmarshal(c, &config)
-		if err != nil {
+		if err := config.prepare(); err != nil {
 			return &Config{}, err
 		}
 	}
 	config.path = path
 	return &config, nil
 }
+
+// prepare resolves the references of the config read from a file, and computes the effective servers.
+func (c *Config) prepare() error {
+	if err := c.resolve(); err != nil {
+		return err
+	}
+	var err error
+	c.effective, err = c.inherit()
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	. "github.com/onsi/gomega"
 	"log/slog"
 	"os"
+	"time"
 )
 
 var _ = Describe("Config", func() {
@@ -30,6 +31,23 @@ var _ = Describe("Config", func() {
 			c, err = data.NewConfig(f.Name(), l)
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("does not save the config when only loading it", func() {
+			l := slog.New(slog.NewTextHandler(GinkgoWriter, nil))
+			f, err := os.CreateTemp(os.TempDir(), "config*.yml")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(f.Name())
+			content := "# servers observed\nServers:\n  - Host: 127.0.0.1 # local\n"
+			Expect(os.WriteFile(f.Name(), []byte(content), 0655)).ToNot(HaveOccurred())
+
+			c, err := data.LoadConfig(f.Name(), l)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Servers).To(HaveLen(1))
+
+			saved, err := os.ReadFile(f.Name())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(saved)).To(Equal(content))
+		})
 	})
 
 	Describe("Fence", func() {
@@ -72,6 +90,28 @@ var _ = Describe("Config", func() {
 			})
 		})
 
+		Context("AppliesTo", func() {
+			It("applies to every role when no roles specified", func() {
+				Expect(data.Fence{}.AppliesTo(api.PlayerRoleCrewman)).To(BeTrue())
+			})
+
+			It("applies only to listed roles", func() {
+				f := data.Fence{Roles: []string{"Spotter", "sniper"}}
+
+				Expect(f.AppliesTo(api.PlayerRoleSniper)).To(BeTrue())
+				Expect(f.AppliesTo(api.PlayerRoleSpotter)).To(BeTrue())
+				Expect(f.AppliesTo(api.PlayerRoleRifleman)).To(BeFalse())
+			})
+
+			It("does not apply to excepted roles", func() {
+				f := data.Fence{ExceptRoles: []string{"Crewman", "Tank Commander"}}
+
+				Expect(f.AppliesTo(api.PlayerRoleTankCommander)).To(BeFalse())
+				Expect(f.AppliesTo(api.PlayerRoleCrewman)).To(BeFalse())
+				Expect(f.AppliesTo(api.PlayerRoleMedic)).To(BeTrue())
+			})
+		})
+
 		Context("Matches", func() {
 			var si *api.GetSessionResponse
 
@@ -141,6 +181,233 @@ var _ = Describe("Config", func() {
 	})
 })
 
+var _ = Describe("Server", func() {
+	Context("Roles", func() {
+		var s data.Server
+
+		BeforeEach(func() {
+			s = data.Server{
+				PunishAfterSeconds: Pointer(15),
+				Roles: map[string]data.RoleRule{
+					"ArmyCommander":  {Exempt: true},
+					"tank_commander": {PunishAfterSeconds: Pointer(30)},
+				},
+			}
+		})
+
+		It("exempts configured roles", func() {
+			Expect(s.IsExemptRole(api.PlayerRoleArmyCommander)).To(BeTrue())
+			Expect(s.IsExemptRole(api.PlayerRoleTankCommander)).To(BeFalse())
+		})
+
+		It("uses the punish delay of the role", func() {
+			Expect(s.PunishAfter(api.PlayerRoleTankCommander)).To(Equal(30 * time.Second))
+		})
+
+		It("falls back to the punish delay of the server", func() {
+			Expect(s.PunishAfter(api.PlayerRoleRifleman)).To(Equal(15 * time.Second))
+		})
+
+		It("defaults to 10 seconds", func() {
+			Expect(data.Server{}.PunishAfter(api.PlayerRoleRifleman)).To(Equal(10 * time.Second))
+		})
+	})
+
+	Context("Grace", func() {
+		It("has no grace periods by default", func() {
+			Expect(data.Server{}.SpawnGrace()).To(BeZero())
+			Expect(data.Server{Grace: &data.Grace{}}.MatchStartGrace()).To(BeZero())
+		})
+
+		It("returns configured grace periods", func() {
+			s := data.Server{Grace: &data.Grace{
+				AfterSpawnSeconds:       Pointer(5),
+				AfterMatchStartSeconds:  Pointer(60),
+				AfterFenceChangeSeconds: Pointer(30),
+			}}
+
+			Expect(s.SpawnGrace()).To(Equal(5 * time.Second))
+			Expect(s.MatchStartGrace()).To(Equal(time.Minute))
+			Expect(s.FenceChangeGrace()).To(Equal(30 * time.Second))
+		})
+	})
+
+	Context("OutsideSpawn", func() {
+		It("ignores outside spawns by default", func() {
+			Expect(data.Server{}.OutsideSpawnPolicy()).To(Equal(data.OutsideSpawnIgnore))
+		})
+
+		It("replaces placeholders of the message", func() {
+			s := data.Server{OutsideSpawn: &data.OutsideSpawn{
+				Policy:  "Grace",
+				Seconds: Pointer(30),
+				Message: Pointer("Go to {area} within {seconds}s"),
+			}}
+
+			Expect(s.OutsideSpawnPolicy()).To(Equal(data.OutsideSpawnGrace))
+			Expect(s.OutsideSpawnMessage(data.DescribeFences([]data.Fence{
+				{X: Pointer("G"), Y: Pointer(4), Numpads: []int{7, 8}},
+				{Y: Pointer(5)},
+				{Y: Pointer(5)},
+			}))).To(Equal("Go to G4 Numpad 7,8, 5 within 30s"))
+		})
+	})
+
+	Context("Enforcement", func() {
+		It("uses the continuous model by default", func() {
+			Expect(data.Server{}.EnforcementModel()).To(Equal(data.EnforcementContinuous))
+			Expect(data.Server{}.BudgetDecay(time.Hour)).To(BeZero())
+		})
+
+		It("defaults the budget to the punish delay", func() {
+			s := data.Server{PunishAfterSeconds: Pointer(20), Enforcement: &data.Enforcement{Model: "budget"}}
+
+			Expect(s.EnforcementModel()).To(Equal(data.EnforcementBudget))
+			Expect(s.Budget(api.PlayerRoleRifleman)).To(Equal(20 * time.Second))
+		})
+
+		It("decays the budget while inside", func() {
+			s := data.Server{Enforcement: &data.Enforcement{
+				BudgetSeconds:         Pointer(60),
+				DecaySecondsPerMinute: Pointer(10),
+			}}
+
+			Expect(s.Budget(api.PlayerRoleRifleman)).To(Equal(time.Minute))
+			Expect(s.BudgetDecay(90 * time.Second)).To(Equal(15 * time.Second))
+		})
+	})
+
+	Context("Vehicles", func() {
+		It("does not consider anyone in a vehicle by default", func() {
+			Expect(data.Server{}.InVehicle(api.PlayerRoleCrewman, 100)).To(BeFalse())
+		})
+
+		It("considers crew roles and fast players in a vehicle", func() {
+			s := data.Server{Vehicles: &data.Vehicles{SpeedMetersPerSecond: Pointer(8.0)}}
+
+			Expect(s.InVehicle(api.PlayerRoleCrewman, 0)).To(BeTrue())
+			Expect(s.InVehicle(api.PlayerRoleRifleman, 5)).To(BeFalse())
+			Expect(s.InVehicle(api.PlayerRoleRifleman, 10)).To(BeTrue())
+		})
+
+		It("defaults the punish delay to the one of the role", func() {
+			s := data.Server{PunishAfterSeconds: Pointer(15), Vehicles: &data.Vehicles{}}
+			Expect(s.VehiclePunishAfter(api.PlayerRoleCrewman)).To(Equal(15 * time.Second))
+
+			s.Vehicles.PunishAfterSeconds = Pointer(30)
+			Expect(s.VehiclePunishAfter(api.PlayerRoleCrewman)).To(Equal(30 * time.Second))
+		})
+	})
+
+	Context("Buffer", func() {
+		It("has no buffer by default", func() {
+			Expect(data.Server{}.BoundaryTolerance()).To(BeZero())
+			Expect(data.Server{}.ReturnMargin()).To(BeZero())
+		})
+
+		It("returns the configured margins", func() {
+			s := data.Server{Buffer: &data.Buffer{ToleranceMeters: Pointer(10.0), ReturnMeters: Pointer(-5.0)}}
+
+			Expect(s.BoundaryTolerance()).To(Equal(10.0))
+			Expect(s.ReturnMargin()).To(BeZero())
+		})
+	})
+
+	Context("Positions", func() {
+		It("uses the defaults", func() {
+			Expect(data.Server{}.MaxPlausibleSpeed()).To(Equal(60.0))
+			Expect(data.Server{}.OutsideSamples()).To(Equal(1))
+		})
+
+		It("ignores invalid values", func() {
+			s := data.Server{Positions: &data.Positions{MaxSpeedMetersPerSecond: Pointer(-1.0), OutsideSamples: Pointer(0)}}
+
+			Expect(s.MaxPlausibleSpeed()).To(Equal(60.0))
+			Expect(s.OutsideSamples()).To(Equal(1))
+		})
+	})
+
+	Context("PreWarning", func() {
+		It("is disabled by default", func() {
+			Expect(data.Server{}.PreWarningDistance()).To(BeZero())
+		})
+
+		It("returns the configured distance", func() {
+			s := data.Server{PreWarning: &data.PreWarning{DistanceMeters: Pointer(40)}}
+
+			Expect(s.PreWarningDistance()).To(Equal(40.0))
+			Expect(s.PreWarningMessage()).To(ContainSubstring("{distance}"))
+		})
+	})
+
+	Context("Polling", func() {
+		It("uses the default intervals", func() {
+			s := data.Server{}
+
+			Expect(s.SessionInterval()).To(Equal(time.Second))
+			Expect(s.PlayersInterval()).To(Equal(500 * time.Millisecond))
+			Expect(s.IdleInterval()).To(Equal(5 * time.Second))
+			Expect(s.BoundaryInterval()).To(Equal(500 * time.Millisecond))
+			Expect(s.AdminLogInterval()).To(Equal(2 * time.Second))
+			Expect(s.InactivityRestart()).To(Equal(2 * time.Hour))
+		})
+
+		It("uses the configured intervals", func() {
+			s := data.Server{Polling: &data.Polling{
+				PlayersMilliseconds:      Pointer(1000),
+				BoundaryMilliseconds:     Pointer(250),
+				InactivityRestartMinutes: Pointer(30),
+			}}
+
+			Expect(s.PlayersInterval()).To(Equal(time.Second))
+			Expect(s.BoundaryInterval()).To(Equal(250 * time.Millisecond))
+			Expect(s.InactivityRestart()).To(Equal(30 * time.Minute))
+		})
+	})
+
+	Context("Connection", func() {
+		It("leaves the pool defaults", func() {
+			Expect(data.Server{}.MaxOpenConnections()).To(BeNil())
+			Expect(data.Server{}.MaxIdleConnections()).To(BeNil())
+		})
+
+		It("limits idle connections to the open connections", func() {
+			s := data.Server{Connection: &data.Connection{MaxOpenConnections: Pointer(4)}}
+
+			Expect(s.MaxIdleConnections()).To(Equal(Pointer(4)))
+		})
+	})
+
+	Context("FenceSet", func() {
+		s := data.Server{
+			AxisFence: []data.Fence{{X: Pointer("A")}},
+			FenceSets: map[string]data.FenceSet{
+				"Midcap": {AxisFence: []data.Fence{{X: Pointer("E")}}},
+			},
+		}
+
+		It("returns the fences of the server without a name", func() {
+			set, ok := s.FenceSet("")
+
+			Expect(ok).To(BeTrue())
+			Expect(set.AxisFence).To(Equal(s.AxisFence))
+		})
+
+		It("returns named fence sets ignoring case", func() {
+			set, ok := s.FenceSet("midcap")
+
+			Expect(ok).To(BeTrue())
+			Expect(set.AxisFence).To(Equal(s.FenceSets["Midcap"].AxisFence))
+		})
+
+		It("does not return unknown fence sets", func() {
+			_, ok := s.FenceSet("lastcap")
+
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
+
 func Pointer[T any](v T) *T {
 	return &v
 }
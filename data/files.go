@@ -0,0 +1,119 @@
+package data
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configExtensions are the extensions of the config files which are read, see decodeConfig.
+var configExtensions = []string{".yml", ".yaml", ".json", ".toml"}
+
+// isYAML reports whether the config file at the path is YAML, which is the format Config.Save writes. Files with an
+// unknown extension are YAML as well.
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext != ".json" && ext != ".toml"
+}
+
+// decodeConfig decodes the config file in the format of its extension. Files with an unknown extension are read as
+// YAML, which is also able to read JSON.
+func decodeConfig(path string, b []byte) (Config, error) {
+	var c Config
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		// Convert TOML to YAML, so that all types are decoded in the same way, including the short forms of fences
+		var v map[string]any
+		if err := toml.Unmarshal(b, &v); err != nil {
+			return c, err
+		}
+		var err error
+		if b, err = yaml.Marshal(v); err != nil {
+			return c, err
+		}
+	}
+	err := yaml.Unmarshal(b, &c)
+	return c, err
+}
+
+// readConfigDir reads all config files in the directory in the order of their names, and merges them into one config.
+// Files starting with a dot and files with other extensions are ignored.
+func readConfigDir(dir string) (Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Config{}, err
+	}
+	var config Config
+	sources := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || !slices.Contains(configExtensions, strings.ToLower(filepath.Ext(e.Name()))) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return Config{}, err
+		}
+		c, err := decodeConfig(e.Name(), b)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		if err := config.merge(c, e.Name(), sources); err != nil {
+			return Config{}, err
+		}
+	}
+	return config, nil
+}
+
+// merge adds the definitions and servers of the config read from the file to c. sources are the files of everything
+// merged so far, to report definitions in more than one file.
+func (c *Config) merge(o Config, file string, sources map[string]string) error {
+	define := func(kind, name string) error {
+		key := kind + " " + name
+		if prev, ok := sources[key]; ok {
+			return fmt.Errorf("%s is defined in %s and %s", key, prev, file)
+		}
+		sources[key] = file
+		return nil
+	}
+	if o.Defaults != nil {
+		if err := define("defaults", "of all servers"); err != nil {
+			return err
+		}
+		c.Defaults = o.Defaults
+	}
+	if err := mergeMap(&c.Conditions, o.Conditions, func(name string) error { return define("condition", name) }); err != nil {
+		return err
+	}
+	if err := mergeMap(&c.FenceGroups, o.FenceGroups, func(name string) error { return define("fence group", name) }); err != nil {
+		return err
+	}
+	if err := mergeMap(&c.ServerGroups, o.ServerGroups, func(name string) error { return define("server group", name) }); err != nil {
+		return err
+	}
+	for _, s := range o.Servers {
+		if err := define("server", fmt.Sprintf("%s:%d", s.Host, s.Port)); err != nil {
+			return err
+		}
+		c.Servers = append(c.Servers, s)
+	}
+	return nil
+}
+
+// mergeMap adds all entries of src to dst, after checking them with define in the order of their keys.
+func mergeMap[T any](dst *map[string]T, src map[string]T, define func(string) error) error {
+	for _, name := range slices.Sorted(maps.Keys(src)) {
+		if err := define(name); err != nil {
+			return err
+		}
+		if *dst == nil {
+			*dst = map[string]T{}
+		}
+		(*dst)[name] = src[name]
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package data_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config files", func() {
+	var dir string
+	write := func(name, content string) {
+		Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0655)).ToNot(HaveOccurred())
+	}
+	load := func(path string) (*data.Config, error) {
+		return data.NewConfig(path, slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp(os.TempDir(), "config")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).ToNot(HaveOccurred())
+	})
+
+	Context("directory", func() {
+		BeforeEach(func() {
+			write("20-servers.yml", `
+Servers:
+  - Host: 1.1.1.1
+    AxisFence: [{Group: carentan, Condition: seeding}]
+`)
+			write("10-carentan.json", `{"FenceGroups": {"carentan": [{"X": "C-E"}]}}`)
+			write("30-more-servers.yaml", "Servers: [{Host: 2.2.2.2}]")
+			write("conditions.toml", `
+[Conditions.seeding.LessThan]
+player_count = 50
+`)
+			write("README.md", "not a config file")
+			write(".10-carentan.json.swp", "not a config file")
+		})
+
+		It("merges all config files", func() {
+			c, err := load(dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			servers := c.EffectiveServers()
+			Expect(servers).To(HaveLen(2))
+			Expect(servers[0].Host).To(Equal("1.1.1.1"))
+			Expect(servers[1].Host).To(Equal("2.2.2.2"))
+			set, _ := servers[0].FenceSet("")
+			Expect(set.AxisFence).To(HaveLen(1))
+			Expect(set.AxisFence[0].Includes(api.Grid{X: "D", Y: 5, Numpad: 5})).To(BeTrue())
+			Expect(set.AxisFence[0].Matches(&api.GetSessionResponse{PlayerCount: 10})).To(BeTrue())
+			Expect(set.AxisFence[0].Matches(&api.GetSessionResponse{PlayerCount: 60})).To(BeFalse())
+		})
+
+		It("does not save the config", func() {
+			c, err := load(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Save()).ToNot(HaveOccurred())
+
+			entries, err := os.ReadDir(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(6))
+			b, err := os.ReadFile(filepath.Join(dir, "30-more-servers.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal("Servers: [{Host: 2.2.2.2}]"))
+		})
+
+		It("rejects definitions in more than one file", func() {
+			write("40-carentan.yml", "FenceGroups: {carentan: [{X: A}]}")
+
+			_, err := load(dir)
+			Expect(err).To(MatchError("fence group carentan is defined in 10-carentan.json and 40-carentan.yml"))
+		})
+
+		It("rejects the same server in more than one file", func() {
+			write("40-servers.yml", "Servers: [{Host: 2.2.2.2}]")
+
+			_, err := load(dir)
+			Expect(err).To(MatchError(ContainSubstring("server 2.2.2.2:0 is defined in 30-more-servers.yaml and 40-servers.yml")))
+		})
+
+		It("reports the file of invalid configs", func() {
+			write("40-invalid.yml", "Servers: [{AxisFence: [{X: K}]}]")
+
+			_, err := load(dir)
+			Expect(err).To(MatchError(ContainSubstring("40-invalid.yml")))
+		})
+	})
+
+	It("reads TOML files", func() {
+		write("config.toml", `
+[[Servers]]
+Host = "1.1.1.1"
+PunishAfterSeconds = 20
+
+[[Servers.AxisFence]]
+X = "A-B"
+Y = [2, 3]
+`)
+		c, err := load(filepath.Join(dir, "config.toml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		s := c.EffectiveServers()[0]
+		Expect(*s.PunishAfterSeconds).To(Equal(20))
+		Expect(s.AxisFence[0].Includes(api.Grid{X: "B", Y: 3, Numpad: 1})).To(BeTrue())
+		Expect(s.AxisFence[0].Includes(api.Grid{X: "B", Y: 4, Numpad: 1})).To(BeFalse())
+	})
+
+	It("reads JSON files without overwriting them", func() {
+		content := `{"Servers": [{"Host": "1.1.1.1", "AxisFence": [{"X": "A"}]}]}`
+		write("config.json", content)
+
+		c, err := load(filepath.Join(dir, "config.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*c.EffectiveServers()[0].AxisFence[0].X).To(Equal("A"))
+		b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal(content))
+	})
+})
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"math"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// mapCenterOffsets are the offsets of the center of maps which are not centered at 0,0, by game mode and map name.
+// They mirror the map data the RCON library uses to calculate the grid of a position, which is not exported.
+var mapCenterOffsets = map[string]map[string]api.Vector2D{
+	"Skirmish": {
+		"CARENTAN":         {X: 150, Y: -110},
+		"MORTAIN":          {X: 100, Y: 0},
+		"ST MARIE DU MONT": {X: 0, Y: -27852.799},
+		"DRIEL":            {X: -20, Y: 28190},
+	},
+}
+
+// Geometry maps the numpads of the grid of a map to world coordinates. World coordinates are in centimetres, the X
+// axis points east and the Y axis points south.
+type Geometry struct {
+	SectorSize float64
+	Offset     api.Vector2D
+}
+
+// NewGeometry returns the geometry of the map of the session, or false if the map is not known.
+func NewGeometry(si *api.GetSessionResponse) (Geometry, bool) {
+	size := si.GridSize()
+	if size == 0 {
+		return Geometry{}, false
+	}
+	return Geometry{SectorSize: size, Offset: mapCenterOffsets[si.GameMode][si.MapName]}, true
+}
+
+// Bounds returns the north-west and south-east corner of the numpad of the grid.
+func (g Geometry) Bounds(grid api.Grid) (api.Vector2D, api.Vector2D, bool) {
+	x, y, ok := gridIndex(grid)
+	if !ok {
+		return api.Vector2D{}, api.Vector2D{}, false
+	}
+	num := g.SectorSize / 3
+	min := api.Vector2D{
+		X: float64(x-len(Columns)/2)*g.SectorSize + float64((grid.Numpad-1)%3)*num + g.Offset.X,
+		Y: float64(y-Rows/2)*g.SectorSize + float64(2-(grid.Numpad-1)/3)*num + g.Offset.Y,
+	}
+	return min, api.Vector2D{X: min.X + num, Y: min.Y + num}, true
+}
+
+// CellBounds returns the north-west and south-east corner of the cell.
+func (g Geometry) CellBounds(c Cell) (api.Vector2D, api.Vector2D, bool) {
+	min, max, ok := g.Bounds(c.Grid)
+	if !ok || c.SubNumpad == 0 {
+		return min, max, ok
+	}
+	if c.SubNumpad < 1 || c.SubNumpad > Numpads {
+		return api.Vector2D{}, api.Vector2D{}, false
+	}
+	sub := g.SectorSize / 9
+	min.X += float64((c.SubNumpad-1)%3) * sub
+	min.Y += float64(2-(c.SubNumpad-1)/3) * sub
+	return min, api.Vector2D{X: min.X + sub, Y: min.Y + sub}, true
+}
+
+// Cell returns the cell of the position, or false if the position is not on the map. The grid of the cell is the
+// same as the one calculated by the RCON library.
+func (g Geometry) Cell(p api.WorldPosition) (Cell, bool) {
+	if !g.OnMap(p) {
+		return Cell{}, false
+	}
+	sub := g.SectorSize / 9
+	// Coordinates of the sub numpad on a 90x90 map of sub numpads, from the north-west
+	x := min(int(math.Floor((p.X-g.Offset.X)/sub))+len(Columns)/2*9, len(Columns)*9-1)
+	y := min(int(math.Floor((p.Y-g.Offset.Y)/sub))+Rows/2*9, Rows*9-1)
+	return Cell{
+		Grid: api.Grid{
+			X:      Columns[x/9],
+			Y:      y/9 + 1,
+			Numpad: (2-y%9/3)*3 + x%9/3 + 1,
+		},
+		SubNumpad: (2-y%3)*3 + x%3 + 1,
+	}, true
+}
+
+// OnMap reports whether the position is within the grid of the map.
+func (g Geometry) OnMap(p api.WorldPosition) bool {
+	half := g.SectorSize * float64(len(Columns)) / 2
+	x, y := p.X-g.Offset.X, p.Y-g.Offset.Y
+	return x >= -half && x < half && y >= -half && y < half
+}
+
+// Nearest returns the point of the area closest to the position, and its distance to the position. It returns false
+// if the area is empty.
+func (g Geometry) Nearest(p api.WorldPosition, area Area) (nearest api.Vector2D, distance float64, ok bool) {
+	distance = math.Inf(1)
+	closest := func(c Cell) {
+		min, max, _ := g.CellBounds(c)
+		v := api.Vector2D{X: math.Max(min.X, math.Min(p.X, max.X)), Y: math.Max(min.Y, math.Min(p.Y, max.Y))}
+		if d := math.Hypot(v.X-p.X, v.Y-p.Y); d < distance {
+			nearest, distance, ok = v, d, true
+		}
+	}
+	for _, column := range Columns {
+		for row := 1; row <= Rows; row++ {
+			for n := 1; n <= Numpads; n++ {
+				grid := api.Grid{X: column, Y: row, Numpad: n}
+				switch covered := area.Covers(grid); covered {
+				case 0:
+				case FullNumpad:
+					closest(Cell{Grid: grid})
+				default:
+					for sub := 1; sub <= Numpads; sub++ {
+						if covered&(1<<(sub-1)) != 0 {
+							closest(Cell{Grid: grid, SubNumpad: sub})
+						}
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+var directions = []string{"north", "north-east", "east", "south-east", "south", "south-west", "west", "north-west"}
+
+// Direction returns the compass direction, e.g. north-east, to go from the position to the target.
+func Direction(from api.WorldPosition, to api.Vector2D) string {
+	// The Y axis points south, so that north is the negative Y direction
+	deg := math.Atan2(to.X-from.X, from.Y-to.Y) * 180 / math.Pi
+	if deg < 0 {
+		deg += 360
+	}
+	return directions[int(math.Round(deg/45))%len(directions)]
+}
@@ -0,0 +1,128 @@
+package data_test
+
+import (
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Geometry", func() {
+	DescribeTable("maps numpads to the same grid as the RCON library", func(si *api.GetSessionResponse) {
+		g, ok := data.NewGeometry(si)
+		Expect(ok).To(BeTrue())
+		for _, x := range data.Columns {
+			for y := 1; y <= data.Rows; y++ {
+				for n := 1; n <= data.Numpads; n++ {
+					grid := api.Grid{X: x, Y: y, Numpad: n}
+					min, max, ok := g.Bounds(grid)
+					Expect(ok).To(BeTrue())
+					center := api.WorldPosition{X: (min.X + max.X) / 2, Y: (min.Y + max.Y) / 2}
+					Expect(center.Grid(si)).To(Equal(grid))
+				}
+			}
+		}
+	},
+		Entry("Warfare", &api.GetSessionResponse{MapName: "FOY", GameMode: "Warfare"}),
+		Entry("Warfare Carentan", &api.GetSessionResponse{MapName: "CARENTAN", GameMode: "Warfare"}),
+		Entry("Skirmish Driel", &api.GetSessionResponse{MapName: "DRIEL", GameMode: "Skirmish"}),
+	)
+
+	DescribeTable("maps positions to sub numpads of the grid of the RCON library", func(si *api.GetSessionResponse) {
+		g, ok := data.NewGeometry(si)
+		Expect(ok).To(BeTrue())
+		for _, x := range data.Columns {
+			for y := 1; y <= data.Rows; y++ {
+				for n := 1; n <= data.Numpads; n++ {
+					for sub := 1; sub <= data.Numpads; sub++ {
+						cell := data.Cell{Grid: api.Grid{X: x, Y: y, Numpad: n}, SubNumpad: sub}
+						min, max, ok := g.CellBounds(cell)
+						Expect(ok).To(BeTrue())
+						center := api.WorldPosition{X: (min.X + max.X) / 2, Y: (min.Y + max.Y) / 2}
+						c, ok := g.Cell(center)
+						Expect(ok).To(BeTrue())
+						Expect(c).To(Equal(cell))
+						Expect(center.Grid(si)).To(Equal(cell.Grid))
+					}
+				}
+			}
+		}
+	},
+		Entry("Warfare", &api.GetSessionResponse{MapName: "FOY", GameMode: "Warfare"}),
+		Entry("Skirmish Driel", &api.GetSessionResponse{MapName: "DRIEL", GameMode: "Skirmish"}),
+	)
+
+	It("does not return cells of positions outside the map", func() {
+		g := data.Geometry{SectorSize: 3000}
+
+		_, ok := g.Cell(api.WorldPosition{X: 15000})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("knows whether positions are on the map", func() {
+		g := data.Geometry{SectorSize: 3000, Offset: api.Vector2D{X: 100}}
+
+		Expect(g.OnMap(api.WorldPosition{X: -14900, Y: 14999})).To(BeTrue())
+		Expect(g.OnMap(api.WorldPosition{X: -15000})).To(BeFalse())
+		Expect(g.OnMap(api.WorldPosition{Y: 15000})).To(BeFalse())
+	})
+
+	It("does not know maps of unknown game modes", func() {
+		_, ok := data.NewGeometry(&api.GetSessionResponse{MapName: "FOY", GameMode: "Unknown"})
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("Nearest", func() {
+		g := data.Geometry{SectorSize: 3000}
+		column := func(x string) *data.CellSet {
+			c := data.Fence{X: &x}.Cells()
+			return &c
+		}
+
+		It("returns the closest point of the included numpads", func() {
+			// E is the column west of the center of the map, spanning from -3000 to 0
+			nearest, d, ok := g.Nearest(api.WorldPosition{X: 2500, Y: 100}, column("E"))
+
+			Expect(ok).To(BeTrue())
+			Expect(nearest).To(Equal(api.Vector2D{X: 0, Y: 100}))
+			Expect(d).To(BeNumerically("~", 2500))
+		})
+
+		It("returns the position itself when it is included", func() {
+			_, d, ok := g.Nearest(api.WorldPosition{X: -100, Y: 100}, column("E"))
+
+			Expect(ok).To(BeTrue())
+			Expect(d).To(BeZero())
+		})
+
+		It("returns false when nothing is included", func() {
+			_, _, ok := g.Nearest(api.WorldPosition{}, &data.CellSet{})
+
+			Expect(ok).To(BeFalse())
+		})
+
+		It("returns the closest point of the included sub numpads", func() {
+			// E5 Numpad 3 spans from -1000 to 0 on both axes, its sub numpad 3 from -333 to 0
+			c := data.Fence{X: Pointer("E"), Y: Pointer(5), Numpads: []int{3}, SubNumpads: []int{3}}.Cells()
+			nearest, d, ok := g.Nearest(api.WorldPosition{X: -900, Y: -100}, &c)
+
+			Expect(ok).To(BeTrue())
+			Expect(nearest.X).To(BeNumerically("~", -333.33, 0.01))
+			Expect(nearest.Y).To(BeNumerically("~", -100))
+			Expect(d).To(BeNumerically("~", 566.67, 0.01))
+		})
+	})
+
+	DescribeTable("Direction", func(to api.Vector2D, expected string) {
+		Expect(data.Direction(api.WorldPosition{}, to)).To(Equal(expected))
+	},
+		Entry("north", api.Vector2D{Y: -100}, "north"),
+		Entry("north-east", api.Vector2D{X: 100, Y: -100}, "north-east"),
+		Entry("east", api.Vector2D{X: 100, Y: 10}, "east"),
+		Entry("south", api.Vector2D{Y: 100}, "south"),
+		Entry("south-west", api.Vector2D{X: -100, Y: 90}, "south-west"),
+		Entry("west", api.Vector2D{X: -100}, "west"),
+		Entry("north-west", api.Vector2D{X: -100, Y: -100}, "north-west"),
+	)
+})
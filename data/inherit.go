@@ -0,0 +1,85 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// inherit returns the effective configuration of the servers, with the settings they do not set themselves taken
+// from their server group and from the defaults of the config.
+func (c *Config) inherit() ([]Server, error) {
+	servers := make([]Server, len(c.Servers))
+	for i, s := range c.Servers {
+		if s.Group != "" {
+			group, ok := c.ServerGroups[s.Group]
+			if !ok {
+				return nil, fmt.Errorf("server %d (%s): unknown server group %s", i+1, s.Host, s.Group)
+			}
+			s = inherit(s, group)
+		}
+		if c.Defaults != nil {
+			s = inherit(s, *c.Defaults)
+		}
+		s.AxisFence = effectiveFences(s.AxisFence)
+		s.AlliesFence = effectiveFences(s.AlliesFence)
+		s.AxisDenyFence = effectiveFences(s.AxisDenyFence)
+		s.AlliesDenyFence = effectiveFences(s.AlliesDenyFence)
+		if s.FenceSets != nil {
+			sets := make(map[string]FenceSet, len(s.FenceSets))
+			for name, set := range s.FenceSets {
+				sets[name] = FenceSet{
+					AxisFence:       effectiveFences(set.AxisFence),
+					AlliesFence:     effectiveFences(set.AlliesFence),
+					AxisDenyFence:   effectiveFences(set.AxisDenyFence),
+					AlliesDenyFence: effectiveFences(set.AlliesDenyFence),
+				}
+			}
+			s.FenceSets = sets
+		}
+		servers[i] = s
+	}
+	return servers, nil
+}
+
+// inherit returns s with the settings it does not set taken from parent. Settings are inherited individually, e.g. a
+// server setting Grace.AfterSpawnSeconds still inherits Grace.AfterMatchStartSeconds, while lists and maps, such as
+// fences, are inherited as a whole. Empty lists are inherited as well, as they are saved like lists which are not set.
+// Settings which can be overridden with zero values are pointers, so that only settings which are not set are zero.
+func inherit(s, parent Server) Server {
+	merge(reflect.ValueOf(&s).Elem(), reflect.ValueOf(parent))
+	return s
+}
+
+// merge sets the fields of dst which are not set to the ones of src, and merges the structs both point to.
+func merge(dst, src reflect.Value) {
+	for i := range dst.NumField() {
+		if !dst.Type().Field(i).IsExported() {
+			continue
+		}
+		d, s := dst.Field(i), src.Field(i)
+		switch {
+		case d.IsZero(), (d.Kind() == reflect.Slice || d.Kind() == reflect.Map) && d.Len() == 0:
+			d.Set(s)
+		case d.Kind() == reflect.Pointer && d.Elem().Kind() == reflect.Struct && !s.IsNil():
+			// Copy the struct, which might be shared with other servers, before merging into it
+			c := reflect.New(d.Elem().Type())
+			c.Elem().Set(d.Elem())
+			merge(c.Elem(), s.Elem())
+			d.Set(c)
+		}
+	}
+}
+
+// effectiveFences returns the fences with fence groups expanded and references to named conditions replaced by the
+// condition, so that they are saved as they apply.
+func effectiveFences(fences []Fence) []Fence {
+	if fences == nil {
+		return nil
+	}
+	effective := expandGroups(fences)
+	effective = append(make([]Fence, 0, len(effective)), effective...)
+	for i := range effective {
+		effective[i].conditionName = ""
+	}
+	return effective
+}
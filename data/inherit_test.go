@@ -0,0 +1,128 @@
+package data_test
+
+import (
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("Inheritance", func() {
+	const config = `
+Conditions:
+  seeding:
+    LessThan:
+      player_count: 50
+Defaults:
+  Password: secret
+  PunishAfterSeconds: 10
+  Grace:
+    AfterSpawnSeconds: 5
+    AfterMatchStartSeconds: 60
+  AxisFence:
+    - X: A
+      Condition: seeding
+ServerGroups:
+  eu:
+    Port: 7779
+    PunishAfterSeconds: 20
+Servers:
+  - Host: 1.1.1.1
+    Group: eu
+    Grace:
+      AfterSpawnSeconds: 10
+  - Host: 2.2.2.2
+    Port: 1234
+    PunishAfterSeconds: 30
+    AxisFence:
+      - X: B
+`
+
+	It("inherits settings from the defaults and server groups", func() {
+		c, _, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		servers := c.EffectiveServers()
+		Expect(servers).To(HaveLen(2))
+		Expect(servers[0].Password).To(Equal("secret"))
+		Expect(servers[0].Port).To(Equal(7779))
+		Expect(*servers[0].PunishAfterSeconds).To(Equal(20))
+		Expect(servers[0].SpawnGrace()).To(Equal(10 * time.Second))
+		Expect(servers[0].MatchStartGrace()).To(Equal(60 * time.Second))
+		Expect(servers[0].AxisFence).To(HaveLen(1))
+		Expect(*servers[0].AxisFence[0].X).To(Equal("A"))
+		Expect(servers[0].AxisFence[0].Condition.LessThan).To(HaveKeyWithValue("player_count", 50))
+	})
+
+	It("overrides inherited settings", func() {
+		c, _, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		s := c.EffectiveServers()[1]
+		Expect(s.Port).To(Equal(1234))
+		Expect(*s.PunishAfterSeconds).To(Equal(30))
+		Expect(s.SpawnGrace()).To(Equal(5 * time.Second))
+		Expect(s.AxisFence).To(HaveLen(1))
+		Expect(*s.AxisFence[0].X).To(Equal("B"))
+	})
+
+	It("overrides inherited settings with zero values", func() {
+		c, _, err := loadConfig(`
+Defaults:
+  PunishAfterSeconds: 10
+  PreWarning:
+    DistanceMeters: 50
+  Buffer:
+    ToleranceMeters: 10
+    ReturnMeters: 5
+  Vehicles:
+    SpeedMetersPerSecond: 8
+    BufferMeters: 20
+Servers:
+  - Host: 1.1.1.1
+    PunishAfterSeconds: 0
+    PreWarning:
+      DistanceMeters: 0
+    Buffer:
+      ToleranceMeters: 0
+    Vehicles:
+      SpeedMetersPerSecond: 0
+      BufferMeters: 0
+`)
+		Expect(err).ToNot(HaveOccurred())
+
+		s := c.EffectiveServers()[0]
+		Expect(s.PunishAfter(api.PlayerRoleRifleman)).To(BeZero())
+		Expect(s.PreWarningDistance()).To(BeZero())
+		Expect(s.BoundaryTolerance()).To(BeZero())
+		Expect(s.ReturnMargin()).To(Equal(5.0))
+		Expect(s.InVehicle(api.PlayerRoleRifleman, 20)).To(BeFalse())
+		Expect(s.VehicleBuffer()).To(BeZero())
+	})
+
+	It("does not change the defaults", func() {
+		c, _, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(*c.Defaults.Grace.AfterSpawnSeconds).To(Equal(5))
+		Expect(c.Servers[0].Password).To(BeEmpty())
+	})
+
+	It("keeps the same effective config when saved", func() {
+		c, content, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		saved, _, err := loadConfig(content)
+		Expect(err).ToNot(HaveOccurred())
+		expected, err := yaml.Marshal(c.EffectiveServers())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(yaml.Marshal(saved.EffectiveServers())).To(Equal(expected))
+	})
+
+	It("rejects unknown server groups", func() {
+		_, _, err := loadConfig("Servers: [{Host: 1.1.1.1, Group: unknown}]")
+		Expect(err).To(MatchError(ContainSubstring("unknown server group unknown")))
+	})
+})
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseColumns returns the columns of s, which is a column (E), a range of columns (C-H) or a comma separated list
+// of both (C,E-G).
+func parseColumns(s string) ([]string, error) {
+	var columns []string
+	err := parseSpan(s, func(v string) (int, bool) {
+		i := slices.Index(Columns, strings.ToUpper(v))
+		return i, i != -1
+	}, func(i int) {
+		columns = append(columns, Columns[i])
+	})
+	return columns, err
+}
+
+// parseRows returns the rows of s, which is a row (5), a range of rows (2-4) or a comma separated list of both
+// (2,5-7).
+func parseRows(s string) ([]int, error) {
+	var rows []int
+	err := parseSpan(s, func(v string) (int, bool) {
+		i, err := strconv.Atoi(v)
+		return i, err == nil && i >= 1 && i <= Rows
+	}, func(i int) {
+		rows = append(rows, i)
+	})
+	return rows, err
+}
+
+// parseSpan parses the comma separated values and ranges of s with parse, and calls add for every value in them.
+// Ranges may be given in any order, e.g. H-C is the same as C-H.
+func parseSpan(s string, parse func(string) (int, bool), add func(int)) error {
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			to = from
+		}
+		f, ok := parse(strings.TrimSpace(from))
+		if !ok {
+			return fmt.Errorf("invalid value %q in %q", from, s)
+		}
+		t, ok := parse(strings.TrimSpace(to))
+		if !ok {
+			return fmt.Errorf("invalid value %q in %q", to, s)
+		}
+		for i := min(f, t); i <= max(f, t); i++ {
+			add(i)
+		}
+	}
+	return nil
+}
+
+// formatSpan returns the values as a sorted, comma separated list, in which consecutive values are shortened to a
+// range.
+func formatSpan(values []int, name func(int) string) string {
+	values = slices.Compact(slices.Sorted(slices.Values(values)))
+	var parts []string
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		switch {
+		case j == i:
+			parts = append(parts, name(values[i]))
+		case j == i+1:
+			parts = append(parts, name(values[i]), name(values[j]))
+		default:
+			parts = append(parts, name(values[i])+"-"+name(values[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// columnIndexes returns the indexes of the columns in Columns.
+func columnIndexes(columns []string) []int {
+	indexes := make([]int, len(columns))
+	for i, c := range columns {
+		indexes[i] = slices.Index(Columns, c)
+	}
+	return indexes
+}
+
+var cellRangePattern = regexp.MustCompile(`^([A-J])(10|[1-9])(?:-([A-J])(10|[1-9]))?(?:[:-]([1-9](?:,[1-9])*))?(?:[:-]([1-9](?:,[1-9])*))?$`)
+
+// parseCellRange returns the area described by s as a fence. s is a grid (E5) or a rectangle of grids given by its
+// corners (D3-G7), optionally followed by the numpads and the sub numpads of each grid, separated by a colon or a dash,
+// e.g. B5:6, E5-7-3 or D3-G7:7,8,9.
+func parseCellRange(s string) (Fence, error) {
+	m := cellRangePattern.FindStringSubmatch(strings.ToUpper(strings.ReplaceAll(s, " ", "")))
+	if m == nil {
+		return Fence{}, fmt.Errorf("invalid cells %q, expected e.g. E5, D3-G7, B5:6 or E5-7-3", s)
+	}
+	if m[3] == "" {
+		m[3], m[4] = m[1], m[2]
+	}
+	var f Fence
+	// The pattern only matches valid columns, rows and numpads
+	f.columns, _ = parseColumns(m[1] + "-" + m[3])
+	f.rows, _ = parseRows(m[2] + "-" + m[4])
+	f.Numpads = numbers(m[5])
+	f.SubNumpads = numbers(m[6])
+	return f, nil
+}
+
+// numbers returns the comma separated single digit numbers of s.
+func numbers(s string) (n []int) {
+	for _, v := range strings.Split(s, ",") {
+		if v != "" {
+			n = append(n, int(v[0]-'0'))
+		}
+	}
+	return
+}
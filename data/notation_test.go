@@ -0,0 +1,80 @@
+package data_test
+
+import (
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+func parseFence(s string) (data.Fence, error) {
+	var f data.Fence
+	err := yaml.Unmarshal([]byte(s), &f)
+	return f, err
+}
+
+var _ = Describe("Notation", func() {
+	DescribeTable("covers the same cells as the expanded fences", func(compact string, expanded []data.Fence) {
+		f, err := parseFence(compact)
+		Expect(err).ToNot(HaveOccurred())
+
+		c, e := f.Cells(), data.FenceCells(expanded)
+		Expect(c).To(Equal(e))
+		for _, x := range data.Columns {
+			for y := 1; y <= data.Rows; y++ {
+				for n := 1; n <= data.Numpads; n++ {
+					g := api.Grid{X: x, Y: y, Numpad: n}
+					Expect(f.Includes(g)).To(Equal(c.Covers(g) != 0), "%s", g)
+				}
+			}
+		}
+	},
+		Entry("single values", "{X: E, Y: 5}", []data.Fence{{X: Pointer("E"), Y: Pointer(5)}}),
+		Entry("column range", "{X: C-E}", []data.Fence{{X: Pointer("C")}, {X: Pointer("D")}, {X: Pointer("E")}}),
+		Entry("reversed column range", "{X: E-C}", []data.Fence{{X: Pointer("C")}, {X: Pointer("D")}, {X: Pointer("E")}}),
+		Entry("column list", "{X: [C, G], Numpad: [7]}", []data.Fence{{X: Pointer("C"), Numpads: []int{7}}, {X: Pointer("G"), Numpads: []int{7}}}),
+		Entry("row list", "{X: A, Y: [2, 3, 4]}", []data.Fence{{X: Pointer("A"), Y: Pointer(2)}, {X: Pointer("A"), Y: Pointer(3)}, {X: Pointer("A"), Y: Pointer(4)}}),
+		Entry("row range and list", "{Y: '1,9-10'}", []data.Fence{{Y: Pointer(1)}, {Y: Pointer(9)}, {Y: Pointer(10)}}),
+		Entry("grid range", "{Cells: [B2-C3]}", []data.Fence{
+			{X: Pointer("B"), Y: Pointer(2)}, {X: Pointer("B"), Y: Pointer(3)}, {X: Pointer("C"), Y: Pointer(2)}, {X: Pointer("C"), Y: Pointer(3)},
+		}),
+		Entry("grid numpad", "{Cells: ['B5:6', 'j10:1,2']}", []data.Fence{{X: Pointer("B"), Y: Pointer(5), Numpads: []int{6}}, {X: Pointer("J"), Y: Pointer(10), Numpads: []int{1, 2}}}),
+		Entry("sub numpad", "{Cells: [E5-7-3]}", []data.Fence{{X: Pointer("E"), Y: Pointer(5), Numpads: []int{7}, SubNumpads: []int{3}}}),
+		Entry("grid range numpads", "{Cells: ['D3-D4:7,8']}", []data.Fence{{X: Pointer("D"), Y: Pointer(3), Numpads: []int{7, 8}}, {X: Pointer("D"), Y: Pointer(4), Numpads: []int{7, 8}}}),
+	)
+
+	DescribeTable("rejects invalid fences", func(s string) {
+		_, err := parseFence(s)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("unknown column", "{X: K}"),
+		Entry("unknown column in range", "{X: A-K}"),
+		Entry("row outside the map", "{Y: 0-3}"),
+		Entry("invalid row in list", "{Y: [1, a]}"),
+		Entry("nested list", "{X: [[A]]}"),
+		Entry("invalid cells", "{Cells: [E11]}"),
+		Entry("cells with X", "{X: A, Cells: [E5]}"),
+	)
+
+	It("keeps the short form when saving", func() {
+		s := "X: C-H\n\"Y\": [2, 3, 4]\nNumpad:\n    - 7\n"
+		f, err := parseFence(s)
+		Expect(err).ToNot(HaveOccurred())
+
+		b, err := yaml.Marshal(f)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal(s))
+	})
+
+	It("describes ranges", func() {
+		f, err := parseFence("{X: [C-E, H], Y: [2, 3, 4]}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.String()).To(Equal("C-E,H 2-4"))
+
+		f, err = parseFence("{Cells: [D3-G7, 'B5:6']}")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.String()).To(Equal("D3-G7 B5:6"))
+	})
+})
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// resolve resolves the references of all fences, including the ones of the defaults and server groups, to named
+// conditions and fence groups of the config. It returns an error for references to unknown names, for groups that
+// refer to themselves and for unknown or duplicate role names.
+func (c *Config) resolve() error {
+	for name, group := range c.FenceGroups {
+		if err := c.resolveFences(group, []string{name}); err != nil {
+			return fmt.Errorf("fence group %s: %w", name, err)
+		}
+	}
+	if c.Defaults != nil {
+		if c.Defaults.Group != "" {
+			return errors.New("defaults cannot have a server group")
+		}
+		if err := c.resolveServer(*c.Defaults); err != nil {
+			return fmt.Errorf("defaults: %w", err)
+		}
+	}
+	for name, s := range c.ServerGroups {
+		if s.Group != "" {
+			return fmt.Errorf("server group %s cannot have a server group", name)
+		}
+		if err := c.resolveServer(s); err != nil {
+			return fmt.Errorf("server group %s: %w", name, err)
+		}
+	}
+	for i, s := range c.Servers {
+		if err := c.resolveServer(s); err != nil {
+			return fmt.Errorf("server %d (%s): %w", i+1, s.Host, err)
+		}
+	}
+	return nil
+}
+
+// resolveServer resolves the references of all fences of the server, and checks the role names of the server.
+func (c *Config) resolveServer(s Server) error {
+	if err := checkRoles(slices.Sorted(maps.Keys(s.Roles))); err != nil {
+		return fmt.Errorf("Roles: %w", err)
+	}
+	if s.Vehicles != nil {
+		if err := checkRoles(s.Vehicles.Roles); err != nil {
+			return fmt.Errorf("Vehicles: %w", err)
+		}
+	}
+	lists := [][]Fence{s.AxisFence, s.AlliesFence, s.AxisDenyFence, s.AlliesDenyFence}
+	for _, set := range s.FenceSets {
+		lists = append(lists, set.AxisFence, set.AlliesFence, set.AxisDenyFence, set.AlliesDenyFence)
+	}
+	for _, fences := range lists {
+		if err := c.resolveFences(fences, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveFences resolves the references of the fences in place. groups are the names of the groups the fences are
+// part of, to detect cycles.
+func (c *Config) resolveFences(fences []Fence, groups []string) error {
+	for i := range fences {
+		f := &fences[i]
+		if err := checkRoles(f.Roles); err != nil {
+			return err
+		}
+		if err := checkRoles(f.ExceptRoles); err != nil {
+			return err
+		}
+		if f.conditionName != "" {
+			cond, ok := c.Conditions[f.conditionName]
+			if !ok {
+				return fmt.Errorf("unknown condition %s", f.conditionName)
+			}
+			f.Condition = &cond
+		}
+		if f.Group == "" {
+			continue
+		}
+		group, ok := c.FenceGroups[f.Group]
+		if !ok {
+			return fmt.Errorf("unknown fence group %s", f.Group)
+		}
+		if slices.Contains(groups, f.Group) {
+			return fmt.Errorf("fence group %s refers to itself through %s", f.Group, strings.Join(groups, ", "))
+		}
+		f.group = slices.Clone(group)
+		if err := c.resolveFences(f.group, append(slices.Clip(groups), f.Group)); err != nil {
+			return err
+		}
+		for j := range f.group {
+			g := &f.group[j]
+			if g.Condition == nil {
+				g.Condition = f.Condition
+			}
+			if len(g.Roles) == 0 {
+				g.Roles = f.Roles
+			}
+			if len(g.ExceptRoles) == 0 {
+				g.ExceptRoles = f.ExceptRoles
+			}
+		}
+	}
+	return nil
+}
+
+// expandGroups returns the fences with references to fence groups replaced by the fences of the group.
+func expandGroups(fences []Fence) []Fence {
+	if !slices.ContainsFunc(fences, func(f Fence) bool { return f.Group != "" }) {
+		return fences
+	}
+	expanded := make([]Fence, 0, len(fences))
+	for _, f := range fences {
+		if f.Group == "" {
+			expanded = append(expanded, f)
+		} else {
+			expanded = append(expanded, expandGroups(f.group)...)
+		}
+	}
+	return expanded
+}
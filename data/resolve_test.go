@@ -0,0 +1,131 @@
+package data_test
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// loadConfig loads the config from a temporary file, and returns it together with the content NewConfig saved.
+func loadConfig(content string) (*data.Config, string, error) {
+	f, err := os.CreateTemp(os.TempDir(), "config*.yml")
+	Expect(err).ToNot(HaveOccurred())
+	defer os.Remove(f.Name())
+	Expect(f.Close()).ToNot(HaveOccurred())
+	Expect(os.WriteFile(f.Name(), []byte(content), 0655)).ToNot(HaveOccurred())
+	c, err := data.NewConfig(f.Name(), slog.New(slog.NewTextHandler(GinkgoWriter, nil)))
+	saved, readErr := os.ReadFile(f.Name())
+	Expect(readErr).ToNot(HaveOccurred())
+	return c, string(saved), err
+}
+
+var _ = Describe("References", func() {
+	const config = `
+Conditions:
+  seeding:
+    Equals:
+      map_name: [CARENTAN]
+    LessThan:
+      player_count: 50
+FenceGroups:
+  lastcap:
+    - X: A
+    - X: B
+      Roles: [Tank Commander]
+Servers:
+  - Host: 127.0.0.1
+    AxisFence:
+      - X: E
+        Condition: seeding
+    AlliesFence:
+      - Group: lastcap
+        Condition: seeding
+    FenceSets:
+      open:
+        AxisFence:
+          - Group: lastcap
+`
+	seeding := &api.GetSessionResponse{MapName: "CARENTAN", PlayerCount: 10}
+	full := &api.GetSessionResponse{MapName: "CARENTAN", PlayerCount: 80}
+
+	It("resolves named conditions", func() {
+		c, _, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		set, _ := c.Servers[0].FenceSet("")
+		Expect(set.AxisFence).To(HaveLen(1))
+		Expect(set.AxisFence[0].Matches(seeding)).To(BeTrue())
+		Expect(set.AxisFence[0].Matches(full)).To(BeFalse())
+	})
+
+	It("expands fence groups", func() {
+		c, _, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		set, _ := c.Servers[0].FenceSet("")
+		Expect(set.AlliesFence).To(HaveLen(2))
+		Expect(*set.AlliesFence[0].X).To(Equal("A"))
+		Expect(set.AlliesFence[0].Matches(full)).To(BeFalse())
+		Expect(set.AlliesFence[1].Roles).To(Equal([]string{"Tank Commander"}))
+
+		open, ok := c.Servers[0].FenceSet("open")
+		Expect(ok).To(BeTrue())
+		Expect(open.AxisFence).To(HaveLen(2))
+		Expect(open.AxisFence[0].Matches(full)).To(BeTrue())
+	})
+
+	It("keeps references when saving", func() {
+		_, saved, err := loadConfig(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(saved).To(ContainSubstring("Condition: seeding"))
+		Expect(saved).To(ContainSubstring("Group: lastcap"))
+		Expect(saved).To(ContainSubstring("player_count: 50"))
+	})
+
+	It("rejects unknown conditions", func() {
+		_, _, err := loadConfig("Servers: [{AxisFence: [{X: A, Condition: unknown}]}]")
+		Expect(err).To(MatchError(ContainSubstring("unknown condition unknown")))
+	})
+
+	It("rejects unknown fence groups", func() {
+		_, _, err := loadConfig("Servers: [{AxisFence: [{Group: unknown}]}]")
+		Expect(err).To(MatchError(ContainSubstring("unknown fence group unknown")))
+	})
+
+	It("rejects fence groups referring to themselves", func() {
+		_, _, err := loadConfig("FenceGroups: {a: [{Group: b}], b: [{Group: a}]}\nServers: []")
+		Expect(err).To(MatchError(ContainSubstring("refers to itself")))
+	})
+
+	It("rejects unknown roles", func() {
+		_, _, err := loadConfig("Servers: [{AxisFence: [{X: A, Roles: [Mashinegunner]}]}]")
+		Expect(err).To(MatchError(ContainSubstring(`unknown role "Mashinegunner"`)))
+
+		_, _, err = loadConfig("FenceGroups: {a: [{X: A, ExceptRoles: [Tanker]}]}\nServers: []")
+		Expect(err).To(MatchError(ContainSubstring(`unknown role "Tanker"`)))
+
+		_, _, err = loadConfig("Servers: [{Roles: {Snipper: {Exempt: true}}}]")
+		Expect(err).To(MatchError(ContainSubstring(`unknown role "Snipper"`)))
+
+		_, _, err = loadConfig("Defaults: {Vehicles: {Roles: [Tank]}}\nServers: []")
+		Expect(err).To(MatchError(ContainSubstring(`unknown role "Tank"`)))
+	})
+
+	It("rejects roles listed more than once", func() {
+		_, _, err := loadConfig("Servers: [{Roles: {Sniper: {Exempt: true}, sniper: {PunishAfterSeconds: 5}}}]")
+		Expect(err).To(MatchError(ContainSubstring(`role "sniper" is listed more than once, as "Sniper"`)))
+
+		_, _, err = loadConfig("Servers: [{AxisFence: [{X: A, Roles: [Tank Commander, TankCommander]}]}]")
+		Expect(err).To(MatchError(ContainSubstring("listed more than once")))
+	})
+
+	It("rejects references with an area", func() {
+		_, _, err := loadConfig("Servers: [{AxisFence: [{Group: a, X: A}]}]")
+		Expect(err).To(HaveOccurred())
+	})
+})
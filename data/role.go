@@ -0,0 +1,58 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// roles maps the role names usable in the config to the roles reported by the game server.
+var roles = map[string]api.PlayerRole{
+	"rifleman":           api.PlayerRoleRifleman,
+	"assault":            api.PlayerRoleAssault,
+	"automaticrifleman":  api.PlayerRoleAutomaticRifleman,
+	"medic":              api.PlayerRoleMedic,
+	"spotter":            api.PlayerRoleSpotter,
+	"support":            api.PlayerRoleSupport,
+	"heavymachinegunner": api.PlayerRoleHeavyMachineGunner,
+	"antitank":           api.PlayerRoleAntiTank,
+	"engineer":           api.PlayerRoleEngineer,
+	"officer":            api.PlayerRoleOfficer,
+	"sniper":             api.PlayerRoleSniper,
+	"crewman":            api.PlayerRoleCrewman,
+	"tankcommander":      api.PlayerRoleTankCommander,
+	"armycommander":      api.PlayerRoleArmyCommander,
+}
+
+// ParseRole returns the role with the given name. The name is matched case-insensitive and ignores spaces, dashes
+// and underscores, e.g. "TankCommander", "tank_commander" and "Tank Commander" are the same role.
+func ParseRole(name string) (api.PlayerRole, bool) {
+	r, ok := roles[strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(name))]
+	return r, ok
+}
+
+func containsRole(names []string, r api.PlayerRole) bool {
+	return slices.ContainsFunc(names, func(name string) bool {
+		role, ok := ParseRole(name)
+		return ok && role == r
+	})
+}
+
+// checkRoles returns an error for names which are not a role, and for roles listed more than once, e.g. as "Sniper"
+// and "sniper". A misspelled role would otherwise silently not match any player.
+func checkRoles(names []string) error {
+	seen := map[api.PlayerRole]string{}
+	for _, name := range names {
+		r, ok := ParseRole(name)
+		if !ok {
+			return fmt.Errorf("unknown role %q", name)
+		}
+		if prev, ok := seen[r]; ok {
+			return fmt.Errorf("role %q is listed more than once, as %q", name, prev)
+		}
+		seen[r] = name
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"gopkg.in/yaml.v3"
+)
+
+type Whitelist struct {
+	// File is the path to an optional YAML file containing a list of additional entries. The file is reloaded when it
+	// changes.
+	File    *string          `yaml:"File,omitempty"`
+	Entries []WhitelistEntry `yaml:"Entries,omitempty"`
+}
+
+// WhitelistEntry exempts players from all fences. All criteria of an entry need to match a player, an entry without
+// any criteria does not match anyone.
+type WhitelistEntry struct {
+	PlayerId string `yaml:"PlayerId,omitempty"`
+	// ClanTag is matched against the clan tag of the player, e.g. "[ABC]". A * matches any characters, e.g. "*ABC*",
+	// all other characters, including brackets, are matched literally.
+	ClanTag  string     `yaml:"ClanTag,omitempty"`
+	MinLevel *int       `yaml:"MinLevel,omitempty"`
+	MaxLevel *int       `yaml:"MaxLevel,omitempty"`
+	Platform string     `yaml:"Platform,omitempty"`
+	Expires  *time.Time `yaml:"Expires,omitempty"`
+	Reason   string     `yaml:"Reason,omitempty"`
+}
+
+func (e WhitelistEntry) Matches(p api.GetPlayerResponse, now time.Time) bool {
+	if e.PlayerId == "" && e.ClanTag == "" && e.MinLevel == nil && e.MaxLevel == nil && e.Platform == "" {
+		return false
+	}
+	if e.Expires != nil && !now.Before(*e.Expires) {
+		return false
+	}
+	if e.PlayerId != "" && e.PlayerId != p.Id {
+		return false
+	}
+	if e.ClanTag != "" {
+		if !matchClanTag(e.ClanTag, p.ClanTag) {
+			return false
+		}
+	}
+	if e.MinLevel != nil && p.Level < *e.MinLevel {
+		return false
+	}
+	if e.MaxLevel != nil && p.Level > *e.MaxLevel {
+		return false
+	}
+	if e.Platform != "" && !strings.EqualFold(e.Platform, string(p.Platform)) {
+		return false
+	}
+	return true
+}
+
+// matchClanTag reports whether the clan tag matches the pattern, in which a * matches any characters and all other
+// characters match themselves.
+func matchClanTag(pattern, tag string) bool {
+	// star is the position of the last * in the pattern and next the position in the tag it is tried to match up to
+	star, next := -1, 0
+	for pi, ti := 0, 0; ti < len(tag) || pi < len(pattern); {
+		switch {
+		case pi < len(pattern) && pattern[pi] == '*':
+			star, next = pi, ti
+			pi++
+		case pi < len(pattern) && ti < len(tag) && pattern[pi] == tag[ti]:
+			pi++
+			ti++
+		case star >= 0 && next < len(tag):
+			// Let the last * match one more character and continue after it
+			next++
+			pi, ti = star+1, next
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WhitelistFile is a list of whitelist entries read from a file outside the config.
+type WhitelistFile struct {
+	path    string
+	mu      sync.RWMutex
+	modTime time.Time
+	entries []WhitelistEntry
+}
+
+func NewWhitelistFile(path string) (*WhitelistFile, error) {
+	f := &WhitelistFile{path: path}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *WhitelistFile) Path() string {
+	return f.path
+}
+
+// Reload reads the file again when it was modified since the last read. It returns true when the entries changed.
+func (f *WhitelistFile) Reload() (bool, error) {
+	s, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.RLock()
+	unchanged := s.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	c, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	var entries []WhitelistEntry
+	if err := yaml.Unmarshal(c, &entries); err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.modTime = s.ModTime()
+	f.entries = entries
+	return true, nil
+}
+
+func (f *WhitelistFile) Entries() []WhitelistEntry {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.entries
+}
@@ -0,0 +1,95 @@
+package data_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Whitelist", func() {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := api.GetPlayerResponse{
+		Id:       "76561198000000001",
+		Platform: api.PlayerPlatformSteam,
+		ClanTag:  "[ABC]",
+		Level:    42,
+	}
+
+	DescribeTable("entry matches player", func(e data.WhitelistEntry, expected bool) {
+		Expect(e.Matches(p, now)).To(Equal(expected))
+	},
+		Entry("empty entry", data.WhitelistEntry{}, false),
+		Entry("same player id", data.WhitelistEntry{PlayerId: p.Id}, true),
+		Entry("other player id", data.WhitelistEntry{PlayerId: "76561198000000002"}, false),
+		Entry("literal clan tag with brackets", data.WhitelistEntry{ClanTag: "[ABC]"}, true),
+		Entry("clan tag pattern", data.WhitelistEntry{ClanTag: "*ABC*"}, true),
+		Entry("clan tag pattern with brackets", data.WhitelistEntry{ClanTag: "[*]"}, true),
+		Entry("other clan tag", data.WhitelistEntry{ClanTag: "XYZ"}, false),
+		Entry("question mark is literal", data.WhitelistEntry{ClanTag: "?ABC?"}, false),
+		Entry("clan tag pattern at the start", data.WhitelistEntry{ClanTag: "*C]"}, true),
+		Entry("clan tag pattern with several stars", data.WhitelistEntry{ClanTag: "*A*C*"}, true),
+		Entry("clan tag pattern which does not match the end", data.WhitelistEntry{ClanTag: "*AB"}, false),
+		Entry("level in range", data.WhitelistEntry{MinLevel: Pointer(10), MaxLevel: Pointer(50)}, true),
+		Entry("level too low", data.WhitelistEntry{MinLevel: Pointer(50)}, false),
+		Entry("level too high", data.WhitelistEntry{MaxLevel: Pointer(20)}, false),
+		Entry("platform", data.WhitelistEntry{Platform: "Steam"}, true),
+		Entry("other platform", data.WhitelistEntry{Platform: "epic"}, false),
+		Entry("not yet expired", data.WhitelistEntry{PlayerId: p.Id, Expires: Pointer(now.Add(time.Hour))}, true),
+		Entry("expired", data.WhitelistEntry{PlayerId: p.Id, Expires: Pointer(now.Add(-time.Hour))}, false),
+		Entry("all criteria need to match", data.WhitelistEntry{PlayerId: p.Id, ClanTag: "XYZ"}, false),
+	)
+
+	It("matches clan tags with slashes", func() {
+		e := data.WhitelistEntry{ClanTag: "*A*"}
+		Expect(e.Matches(api.GetPlayerResponse{ClanTag: "[A/B]"}, now)).To(BeTrue())
+		Expect(data.WhitelistEntry{ClanTag: "[A/*]"}.Matches(api.GetPlayerResponse{ClanTag: "[A/B]"}, now)).To(BeTrue())
+		Expect(data.WhitelistEntry{ClanTag: "[A/B]"}.Matches(api.GetPlayerResponse{ClanTag: "[A/B]"}, now)).To(BeTrue())
+		Expect(e.Matches(api.GetPlayerResponse{ClanTag: "[C/D]"}, now)).To(BeFalse())
+	})
+
+	It("does not treat brackets in clan tags as character class", func() {
+		e := data.WhitelistEntry{ClanTag: "[ABC]"}
+		Expect(e.Matches(api.GetPlayerResponse{ClanTag: "A"}, now)).To(BeFalse())
+		Expect(e.Matches(api.GetPlayerResponse{ClanTag: "[ABC]"}, now)).To(BeTrue())
+	})
+
+	It("matches entries of the server and additional entries", func() {
+		s := data.Server{Whitelist: &data.Whitelist{Entries: []data.WhitelistEntry{{ClanTag: "XYZ"}}}}
+
+		_, ok := s.WhitelistEntry(p, now)
+		Expect(ok).To(BeFalse())
+
+		e, ok := s.WhitelistEntry(p, now, data.WhitelistEntry{PlayerId: p.Id, Reason: "admin"})
+		Expect(ok).To(BeTrue())
+		Expect(e.Reason).To(Equal("admin"))
+	})
+
+	It("reloads the whitelist file when changed", func() {
+		f, err := os.CreateTemp(os.TempDir(), "whitelist")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+		Expect(os.WriteFile(f.Name(), []byte("- PlayerId: \"1\"\n"), 0655)).ToNot(HaveOccurred())
+
+		w, err := data.NewWhitelistFile(f.Name())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Entries()).To(HaveLen(1))
+
+		changed, err := w.Reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		Expect(os.WriteFile(f.Name(), []byte("- PlayerId: \"1\"\n- ClanTag: \"[ABC]\"\n  Expires: 2030-01-01T00:00:00Z\n"), 0655)).ToNot(HaveOccurred())
+		Expect(os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Minute))).ToNot(HaveOccurred())
+
+		changed, err = w.Reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(w.Entries()).To(HaveLen(2))
+		Expect(w.Entries()[1].Expires).To(Equal(Pointer(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))))
+	})
+})
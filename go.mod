@@ -3,7 +3,9 @@ module github.com/floriansw/hll-geofences
 go 1.24.1
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/floriansw/go-hll-rcon v0.0.0-20250629132557-f7b6f61dd58a
+	github.com/joho/godotenv v1.5.1
 	github.com/onsi/ginkgo v1.16.5
 	github.com/onsi/gomega v1.36.2
 	gopkg.in/yaml.v3 v3.0.1
@@ -12,7 +14,6 @@ require (
 require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/nxadm/tail v1.4.8 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,18 @@
+package sync
+
+import "sync/atomic"
+
+type Value[V any] struct {
+	v atomic.Value
+}
+
+func (v *Value[V]) Load() (value V) {
+	if l := v.v.Load(); l != nil {
+		return l.(V)
+	}
+	return value
+}
+
+func (v *Value[V]) Store(value V) {
+	v.v.Store(value)
+}
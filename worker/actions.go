@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2"
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// actionWorkers is the number of commands executed concurrently against the server, see commandQueue.
+const actionWorkers = 4
+
+// warningExpiry is the time after which a warning which could not be sent yet is dropped.
+const warningExpiry = 30 * time.Second
+
+// punishRetryDelay is the time after which a failed punishment is tried again. It doubles with each failure of the
+// same out-of-bounds period, up to maxPunishRetryDelay.
+const (
+	punishRetryDelay    = 5 * time.Second
+	maxPunishRetryDelay = time.Minute
+)
+
+// preWarningExpiry is the time after which a pre-warning which could not be sent yet is dropped, as the player moved
+// on in the meantime.
+const preWarningExpiry = 5 * time.Second
+
+type actionKind int
+
+const (
+	actionWarn actionKind = iota
+	actionPunish
+	actionPreWarn
+)
+
+func (k actionKind) String() string {
+	switch k {
+	case actionWarn:
+		return "warn"
+	case actionPunish:
+		return "punish"
+	case actionPreWarn:
+		return "pre-warn"
+	}
+	return "unknown"
+}
+
+// action is a command to be sent to the server as the result of evaluating players.
+type action struct {
+	Kind       actionKind
+	PlayerId   string
+	PlayerName string
+	Grid       api.Grid
+	Message    string
+	// Stale reports whether the out-of-bounds period the action was created for already ended.
+	Stale func() bool
+}
+
+// connection is the part of a connection to the server the worker uses.
+type connection interface {
+	Players(ctx context.Context) (*api.GetPlayersResponse, error)
+	SessionInfo(ctx context.Context) (*api.GetSessionResponse, error)
+	AdminLog(ctx context.Context, timeSeconds int32, filter string) (*api.GetAdminLogResponse, error)
+	MessagePlayer(ctx context.Context, playerId, message string) error
+	PunishPlayer(ctx context.Context, playerId, reason string) error
+}
+
+// poolConnect returns a function running f with a connection of the pool.
+func poolConnect(pool *rconv2.ConnectionPool) func(ctx context.Context, f func(c connection) error) error {
+	return func(ctx context.Context, f func(c connection) error) error {
+		return pool.WithConnection(ctx, func(c *rconv2.Connection) error {
+			return f(c)
+		})
+	}
+}
+
+// rcon runs f with a connection of the pool through the command queue and waits for the result.
+func (w *Worker) rcon(ctx context.Context, p priority, name string, f func(c connection) error) error {
+	done := make(chan error, 1)
+	w.queue.Push(&command{Priority: p, Name: name, Run: w.withConnection(f), Done: func(err error) { done <- err }})
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withConnection runs f with a connection of the pool. Other than the pool, it returns the error of f.
+func (w *Worker) withConnection(f func(c connection) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var ferr error
+		err := w.connect(ctx, func(c connection) error {
+			ferr = f(c)
+			return ferr
+		})
+		if err != nil {
+			return err
+		}
+		return ferr
+	}
+}
+
+// enqueue adds the action to the command queue without waiting for it to be executed.
+func (w *Worker) enqueue(ctx context.Context, a action) {
+	c := &command{Name: a.Kind.String(), Target: a.PlayerName, Stale: a.Stale}
+	switch a.Kind {
+	case actionWarn:
+		c.Priority = priorityWarn
+		c.Expires = time.Now().Add(warningExpiry)
+		c.Run = w.withConnection(func(c connection) error {
+			return c.MessagePlayer(ctx, a.PlayerName, a.Message)
+		})
+		c.Done = func(err error) {
+			if err != nil && err != errStaleCommand {
+				w.l.Error("message-player-outside-fence", "player", a.PlayerName, "grid", a.Grid, "error", err)
+			}
+		}
+	case actionPreWarn:
+		c.Priority = priorityWarn
+		c.Expires = time.Now().Add(preWarningExpiry)
+		c.Run = w.withConnection(func(c connection) error {
+			return c.MessagePlayer(ctx, a.PlayerName, a.Message)
+		})
+		c.Done = func(err error) {
+			if err != nil && err != errStaleCommand {
+				w.l.Error("message-player-near-boundary", "player", a.PlayerName, "grid", a.Grid, "error", err)
+			}
+		}
+	case actionPunish:
+		c.Priority = priorityPunish
+		c.Run = w.withConnection(func(c connection) error {
+			return c.PunishPlayer(ctx, a.PlayerId, a.Message)
+		})
+		c.Done = func(err error) {
+			if err == errStaleCommand {
+				return
+			} else if err != nil {
+				w.l.Error("punish-player", "player_id", a.PlayerId, "error", err)
+				w.post(func() { w.punishFailed(ctx, a.PlayerId) })
+				return
+			}
+			w.l.Info("punish-player", "player", a.PlayerName, "grid", a.Grid.String())
+			w.post(func() { w.punished(ctx, a.PlayerId) })
+		}
+	default:
+		panic(fmt.Sprintf("unknown action %s", a.Kind))
+	}
+	w.queue.Push(c)
+}
+
+// schedulePunishment punishes the player at the given time, unless the out-of-bounds period of the player ends
+// before.
+func (w *Worker) schedulePunishment(ctx context.Context, id string, at time.Time) {
+	w.punishments.Schedule(id, at, func() {
+		w.do(func() { w.punishDue(ctx, id) })
+	})
+}
+
+// punishDue enqueues the punishment of a player whose out-of-bounds deadline passed. It must only be called from the
+// evaluation loop.
+func (w *Worker) punishDue(ctx context.Context, id string) {
+	st, ok := w.players[id]
+	if !ok || st.Outside == nil {
+		return
+	}
+	message := w.c.PunishMessage()
+	w.l.Debug("punish-message-final", "message", message)
+	w.enqueue(ctx, action{Kind: actionPunish, PlayerId: id, PlayerName: st.Outside.Name, Grid: st.Outside.LastGrid, Message: message, Stale: st.Outside.Ended.Load})
+}
+
+// punishFailed punishes a player who is still outside again later, after the punishment failed or was dropped. It must
+// only be called from the evaluation loop.
+func (w *Worker) punishFailed(ctx context.Context, id string) {
+	st, ok := w.players[id]
+	if !ok || st.Outside == nil {
+		return
+	}
+	// A new deadline was scheduled in the meantime, e.g. for a new out-of-bounds period
+	if _, ok := w.punishments.Deadline(id); ok {
+		return
+	}
+	o := st.Outside
+	delay := min(punishRetryDelay<<min(o.PunishFailures, 4), maxPunishRetryDelay)
+	o.PunishFailures++
+	w.l.Info("punish-player-retry", "player", o.Name, "delay", delay)
+	w.schedulePunishment(ctx, id, time.Now().Add(delay))
+}
+
+// punished continues the out-of-bounds period of a punished player. When configured, the punishment is repeated for
+// as long as the player stays outside. It must only be called from the evaluation loop.
+func (w *Worker) punished(ctx context.Context, id string) {
+	st, ok := w.players[id]
+	if !ok {
+		return
+	}
+	st.Budget = nil
+	if st.Outside == nil {
+		return
+	}
+	// The punishment used up the budget, so that the rest of the out-of-bounds period is not counted
+	st.Outside.Budget = false
+	if repeat := w.c.RepeatPunishAfter(); repeat > 0 {
+		w.schedulePunishment(ctx, id, time.Now().Add(repeat))
+		return
+	}
+	// Give the punished player some time to respawn before a new out-of-bounds period starts
+	w.punishments.Schedule(id, time.Now().Add(5*time.Second), func() {
+		w.do(func() {
+			if st, ok := w.players[id]; ok {
+				st.endOutside(time.Now())
+			}
+		})
+	})
+}
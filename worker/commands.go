@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/adminlog"
+	"github.com/floriansw/hll-geofences/data"
+)
+
+var commandNames = []string{"seed", "fence", "exempt"}
+
+// handleCommand executes an admin chat command, e.g. !seed off. lastCommand holds the time of the last command of each
+// admin for rate limiting.
+func (w *Worker) handleCommand(ctx context.Context, chat adminlog.Chat, lastCommand map[string]time.Time) {
+	if w.c.Commands == nil || !strings.HasPrefix(chat.Message, "!") {
+		return
+	}
+	args := strings.Fields(strings.TrimPrefix(chat.Message, "!"))
+	if len(args) == 0 || !slices.Contains(commandNames, strings.ToLower(args[0])) {
+		return
+	}
+	l := w.l.With("player", chat.PlayerName, "player_id", chat.PlayerId, "command", chat.Message)
+	if !w.c.Commands.IsAdmin(chat.PlayerId) {
+		l.Warn("unauthorized-command")
+		return
+	}
+	if last, ok := lastCommand[chat.PlayerId]; ok && time.Since(last) < w.c.Commands.Cooldown() {
+		l.Warn("command-rate-limited")
+		return
+	}
+	lastCommand[chat.PlayerId] = time.Now()
+
+	reply, err := w.runCommand(ctx, strings.ToLower(args[0]), args[1:])
+	if err != nil {
+		l.Warn("admin-command-failed", "error", err)
+		reply = err.Error()
+	} else {
+		l.Info("admin-command")
+	}
+
+	err = w.rcon(ctx, priorityWarn, "reply-admin-command", func(c connection) error {
+		return c.MessagePlayer(ctx, chat.PlayerId, reply)
+	})
+	if err != nil {
+		l.Error("reply-admin-command", "error", err)
+	}
+}
+
+func (w *Worker) runCommand(ctx context.Context, name string, args []string) (string, error) {
+	switch name {
+	case "seed":
+		return w.seedCommand(ctx, args)
+	case "fence":
+		return w.fenceCommand(args)
+	case "exempt":
+		return w.exemptCommand(ctx, args)
+	}
+	return "", fmt.Errorf("unknown command %s", name)
+}
+
+// seedCommand switches between the fence sets of the server, e.g. !seed midcap, !seed default or !seed off.
+func (w *Worker) seedCommand(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: !seed <fence set|default|off>")
+	}
+	switch set := strings.ToLower(args[0]); set {
+	case "off":
+		w.disabled.Store(true)
+		w.do(w.resetOutsidePlayers)
+		return "Fences disabled", nil
+	case "on", "default":
+		w.fenceSet.Store("")
+	default:
+		if _, ok := w.c.FenceSet(set); !ok {
+			return "", fmt.Errorf("unknown fence set %s", set)
+		}
+		w.fenceSet.Store(set)
+	}
+	w.disabled.Store(false)
+	w.do(w.resetOutsidePlayers)
+	if err := w.refreshSession(ctx); err != nil {
+		return "", err
+	}
+	return "Fences active: " + w.fenceSetName(), nil
+}
+
+// fenceCommand pauses or resumes the enforcement of fences, e.g. !fence pause 10m, !fence resume or !fence status.
+func (w *Worker) fenceCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("usage: !fence <pause <duration>|resume|status>")
+	}
+	switch strings.ToLower(args[0]) {
+	case "pause":
+		if len(args) != 2 {
+			return "", errors.New("usage: !fence pause <duration>")
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return "", fmt.Errorf("invalid duration %s", args[1])
+		}
+		w.pausedUntil.Store(time.Now().Add(d))
+		w.do(w.resetOutsidePlayers)
+		return fmt.Sprintf("Fences paused for %s", d), nil
+	case "resume":
+		w.pausedUntil.Store(time.Time{})
+		return "Fences resumed", nil
+	case "status":
+		return w.status(), nil
+	}
+	return "", fmt.Errorf("unknown fence command %s", args[0])
+}
+
+// exemptCommand exempts an online player from all fences for some time, e.g. !exempt ToastyMcToast 30m.
+func (w *Worker) exemptCommand(ctx context.Context, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("usage: !exempt <player name> <duration>")
+	}
+	d, err := time.ParseDuration(args[len(args)-1])
+	if err != nil || d <= 0 {
+		return "", fmt.Errorf("invalid duration %s", args[len(args)-1])
+	}
+	name := strings.ToLower(strings.Join(args[:len(args)-1], " "))
+
+	var matches []api.GetPlayerResponse
+	err = w.rcon(ctx, priorityPoll, "players", func(c connection) error {
+		players, err := c.Players(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range players.Players {
+			if strings.Contains(strings.ToLower(p.Name), name) {
+				matches = append(matches, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("%d players match %s", len(matches), name)
+	}
+	p := matches[0]
+	w.exemptions.Store(p.Id, time.Now().Add(d))
+	w.do(func() { w.clearOutside(p.Id, time.Now()) })
+	return fmt.Sprintf("%s exempted for %s", p.Name, d), nil
+}
+
+func (w *Worker) status() string {
+	s := "Fences: " + w.fenceSetName()
+	if until := w.pausedUntil.Load(); time.Now().Before(until) {
+		s += fmt.Sprintf(", paused for %s", time.Until(until).Round(time.Second))
+	}
+	s += fmt.Sprintf(", %d players outside", w.outside.Load())
+	s += ", enforcement: " + w.c.EnforcementModel()
+	if policy := w.c.OutsideSpawnPolicy(); policy == data.OutsideSpawnGrace {
+		s += fmt.Sprintf(", outside spawns: %s %s", policy, w.c.OutsideSpawnTime())
+	} else {
+		s += ", outside spawns: " + policy
+	}
+	s += ", " + w.diagnostics.String()
+	return s
+}
+
+func (w *Worker) fenceSetName() string {
+	if w.disabled.Load() {
+		return "off"
+	}
+	if set := w.fenceSet.Load(); set != "" {
+		return set
+	}
+	return "default"
+}
@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/adminlog"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("handleCommand", func() {
+	var w *Worker
+	var server *fakeServer
+	var log *gbytes.Buffer
+	var lastCommand map[string]time.Time
+	var cancel context.CancelFunc
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		w = newTestWorker(data.Server{
+			AlliesFence: []data.Fence{{X: Pointer("E")}},
+			FenceSets:   map[string]data.FenceSet{"midcap": {AlliesFence: []data.Fence{{X: Pointer("F")}}}},
+			Commands:    &data.Commands{Admins: []string{"admin", "other-admin"}},
+		})
+		log = gbytes.NewBuffer()
+		w.l = slog.New(slog.NewTextHandler(log, nil))
+		server = newFakeServer(carentan)
+		lastCommand = map[string]time.Time{}
+		var runCtx context.Context
+		runCtx, cancel = context.WithCancel(ctx)
+		runWorker(runCtx, w, server)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	command := func(playerId, message string) {
+		w.handleCommand(ctx, adminlog.Chat{PlayerName: "name-" + playerId, PlayerId: playerId, Message: message}, lastCommand)
+	}
+	replies := func() []string {
+		var r []string
+		for _, m := range server.Messages() {
+			Expect(m.PlayerId).To(Equal("admin"))
+			r = append(r, m.Message)
+		}
+		return r
+	}
+	outside := func(id string) {
+		inLoop(w, func() {
+			w.players[id] = &playerState{Tracked: true, Outside: &outsidePlayer{FirstOutside: time.Now()}}
+		})
+	}
+	isOutside := func(id string) (ok bool) {
+		inLoop(w, func() { ok = w.players[id].Outside != nil })
+		return
+	}
+
+	It("ignores commands of players who are not admins", func() {
+		command("player", "!seed off")
+
+		Expect(w.disabled.Load()).To(BeFalse())
+		Expect(server.Messages()).To(BeEmpty())
+		Expect(log).To(gbytes.Say("unauthorized-command.*player_id=player"))
+	})
+
+	It("ignores chat messages which are not commands", func() {
+		command("admin", "seed off")
+		command("admin", "!unknown")
+
+		Expect(server.Messages()).To(BeEmpty())
+		Expect(lastCommand).To(BeEmpty())
+	})
+
+	It("limits the commands of each admin", func() {
+		command("admin", "!fence status")
+		command("admin", "!seed off")
+
+		Expect(w.disabled.Load()).To(BeFalse())
+		Expect(replies()).To(HaveLen(1))
+		Expect(log).To(gbytes.Say("command-rate-limited.*player_id=admin"))
+
+		command("other-admin", "!seed off")
+		Expect(w.disabled.Load()).To(BeTrue())
+	})
+
+	It("disables the fences", func() {
+		outside("1")
+
+		command("admin", "!seed off")
+
+		Expect(w.disabled.Load()).To(BeTrue())
+		Expect(w.suspended()).To(BeTrue())
+		Expect(isOutside("1")).To(BeFalse())
+		Expect(replies()).To(Equal([]string{"Fences disabled"}))
+	})
+
+	It("activates a fence set", func() {
+		command("admin", "!seed Midcap")
+
+		Expect(w.fenceSet.Load()).To(Equal("midcap"))
+		Expect(w.disabled.Load()).To(BeFalse())
+		Expect(w.session.Load().allies.allow).To(Equal([]data.Fence{{X: Pointer("F")}}))
+		Expect(replies()).To(Equal([]string{"Fences active: midcap"}))
+	})
+
+	It("replies with an error for unknown fence sets", func() {
+		command("admin", "!seed lastcap")
+
+		Expect(w.fenceSet.Load()).To(BeEmpty())
+		Expect(replies()).To(Equal([]string{"unknown fence set lastcap"}))
+		Expect(log).To(gbytes.Say("admin-command-failed"))
+	})
+
+	It("pauses and resumes the fences", func() {
+		outside("1")
+
+		command("admin", "!fence pause 10m")
+
+		Expect(w.suspended()).To(BeTrue())
+		Expect(w.pausedUntil.Load()).To(BeTemporally("~", time.Now().Add(10*time.Minute), time.Second))
+		Expect(isOutside("1")).To(BeFalse())
+
+		delete(lastCommand, "admin")
+		command("admin", "!fence resume")
+
+		Expect(w.suspended()).To(BeFalse())
+		Expect(replies()).To(Equal([]string{"Fences paused for 10m0s", "Fences resumed"}))
+	})
+
+	It("replies with the status", func() {
+		command("admin", "!fence status")
+
+		r := replies()
+		Expect(r).To(HaveLen(1))
+		Expect(r[0]).To(HavePrefix("Fences: default, 0 players outside, enforcement: "))
+	})
+
+	It("exempts a player", func() {
+		server.setPlayers(
+			api.GetPlayerResponse{Id: "1", Name: "ToastyMcToast"},
+			api.GetPlayerResponse{Id: "2", Name: "Someone"},
+		)
+		outside("1")
+
+		command("admin", "!exempt toasty 30m")
+
+		until, ok := w.exemptions.Load("1")
+		Expect(ok).To(BeTrue())
+		Expect(until).To(BeTemporally("~", time.Now().Add(30*time.Minute), time.Second))
+		Expect(isOutside("1")).To(BeFalse())
+		Expect(replies()).To(Equal([]string{"ToastyMcToast exempted for 30m0s"}))
+	})
+
+	It("does not exempt anyone when the name is ambiguous", func() {
+		server.setPlayers(
+			api.GetPlayerResponse{Id: "1", Name: "ToastyMcToast"},
+			api.GetPlayerResponse{Id: "2", Name: "Toasty"},
+		)
+
+		command("admin", "!exempt toasty 30m")
+
+		_, ok := w.exemptions.Load("1")
+		Expect(ok).To(BeFalse())
+		Expect(replies()).To(Equal([]string{"2 players match toasty"}))
+	})
+})
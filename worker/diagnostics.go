@@ -0,0 +1,19 @@
+package worker
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// diagnostics count events which are not visible in the behaviour of the worker otherwise.
+type diagnostics struct {
+	// DiscardedSamples is the number of player positions discarded as implausible.
+	DiscardedSamples atomic.Int64
+	// UnconfirmedOutside is the number of outside positions which did not start an out-of-bounds period, as the
+	// player was not outside for enough consecutive polls.
+	UnconfirmedOutside atomic.Int64
+}
+
+func (d *diagnostics) String() string {
+	return fmt.Sprintf("%d positions discarded, %d outside positions unconfirmed", d.DiscardedSamples.Load(), d.UnconfirmedOutside.Load())
+}
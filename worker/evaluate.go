@@ -0,0 +1,434 @@
+package worker
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+)
+
+// playerState is the enforcement state of a single player.
+type playerState struct {
+	// Tracked indicates that the player entered an allowed fence at least once.
+	Tracked bool
+	Spawn   spawn
+	// Budget is the out-of-bounds time the player used in the current match with the budget enforcement model.
+	Budget  *budget
+	Outside *outsidePlayer
+	// NearBoundary indicates that the player was inside the fences, but next to their boundary when last evaluated.
+	NearBoundary bool
+	// BoundaryDistance is the distance of the player to the boundary of the fences in metres when last evaluated
+	// inside the fences, or 0 if it is not known. It is only tracked when pre-warnings are enabled.
+	BoundaryDistance float64
+	// PreWarned indicates that the player was warned about approaching the boundary, until they move away from it.
+	PreWarned bool
+	Movement  movement
+	// Discarded is the last position of the player discarded as implausible, if the position after it was not
+	// accepted yet.
+	Discarded *sample
+	// OutsideSamples is the number of consecutive polls the player was outside the fences.
+	OutsideSamples int
+}
+
+type sample struct {
+	Position api.WorldPosition
+	At       time.Time
+}
+
+type movement struct {
+	Position api.WorldPosition
+	At       time.Time
+	// Speed is the estimated speed in metres per second.
+	Speed float64
+}
+
+type spawn struct {
+	// Known indicates that the player was observed before.
+	Known   bool
+	Spawned bool
+	// At is the time the player last spawned, or zero if the player was already spawned when first seen.
+	At time.Time
+}
+
+type budget struct {
+	Used time.Duration
+	// Since is the time the last out-of-bounds period of the player ended, e.g. when they returned to the fences.
+	Since  time.Time
+	Warned bool
+}
+
+type outsidePlayer struct {
+	Name         string
+	LastGrid     api.Grid
+	FirstOutside time.Time
+	PunishAfter  time.Duration
+	WarnAfter    time.Duration
+	Warned       bool
+	// Budget indicates that the player is handled with the budget enforcement model.
+	Budget bool
+	// PunishFailures is the number of punishments of the out-of-bounds period which failed, see punishFailed.
+	PunishFailures int
+	// Ended is set once the out-of-bounds period ended, so that pending actions for it are dropped.
+	Ended atomic.Bool
+}
+
+// endOutside ends the out-of-bounds period of the player, if any. With the budget enforcement model, the time of the
+// period is kept as used budget, however the period ended.
+func (st *playerState) endOutside(now time.Time) {
+	o := st.Outside
+	if o == nil {
+		return
+	}
+	if o.Budget {
+		st.Budget = &budget{Used: now.Sub(o.FirstOutside), Since: now, Warned: o.Warned}
+	}
+	o.Ended.Store(true)
+	st.Outside = nil
+}
+
+// evaluate checks all players against the fences of the session snapshot and returns the resulting actions. It must
+// only be called from the evaluation loop.
+func (w *Worker) evaluate(ctx context.Context, s *session, players []api.GetPlayerResponse, now time.Time) (actions []action) {
+	seen := make(map[string]struct{}, len(players))
+	for _, p := range players {
+		seen[p.Id] = struct{}{}
+		w.playerIds.Store(p.Name, p.Id)
+		if a, ok := w.evaluatePlayer(ctx, s, p, now); ok {
+			actions = append(actions, a)
+		}
+	}
+	outside, near := 0, 0
+	left := map[string]struct{}{}
+	for id, st := range w.players {
+		if _, ok := seen[id]; !ok {
+			w.forgetPlayer(id)
+			left[id] = struct{}{}
+		} else if st.Outside != nil {
+			outside++
+		} else if st.NearBoundary {
+			near++
+		}
+	}
+	// Drop the names of players who left, including previous names of players who changed it
+	if len(left) != 0 {
+		w.playerIds.Range(func(name, id string) bool {
+			if _, ok := left[id]; ok {
+				w.playerIds.Delete(name)
+			}
+			return true
+		})
+	}
+	w.outside.Store(outside)
+	w.nearBoundary = near
+	return
+}
+
+func (w *Worker) evaluatePlayer(ctx context.Context, s *session, p api.GetPlayerResponse, now time.Time) (action, bool) {
+	st, ok := w.players[p.Id]
+	if !ok {
+		st = &playerState{}
+		w.players[p.Id] = st
+	}
+	st.NearBoundary = false
+
+	// Skip whitelisted, temporarily exempted players and exempted roles
+	if w.isWhitelisted(p, now) || w.isExempted(p, now) || w.c.IsExemptRole(p.Role) {
+		w.clearOutside(p.Id, now)
+		st.Tracked = false
+		return action{}, false
+	}
+
+	updateSpawn(st, p, now)
+	if !p.Position.IsSpawned() {
+		// Players who are not on the map start over when they spawn again
+		st.BoundaryDistance, st.PreWarned, st.OutsideSamples, st.Discarded = 0, false, 0, nil
+		w.clearOutside(p.Id, now)
+		return action{}, false
+	}
+	if (s.geometry != nil && !s.geometry.OnMap(p.Position)) || !w.acceptPosition(st, p, now) {
+		w.diagnostics.DiscardedSamples.Add(1)
+		w.l.Debug("position-discarded", "player", p.Name, "x", p.Position.X, "y", p.Position.Y)
+		return action{}, false
+	}
+	w.updateMovement(st, p, now)
+	vehicle := w.c.InVehicle(p.Role, st.Movement.Speed)
+
+	fences := s.compiledFences(p)
+	if !fences.applies() {
+		return action{}, false
+	}
+
+	cell := s.cell(p.Position)
+	g := cell.Grid
+	// Start tracking player only after they enter an allowed fence
+	if w.inside(s, fences, st, p, cell, vehicle) {
+		st.Tracked = true
+		st.NearBoundary = fences.nearBoundary(cell)
+		w.clearOutside(p.Id, now)
+		st.OutsideSamples = 0
+		if !fences.includes(cell) {
+			return action{}, false
+		}
+		return w.preWarning(s, fences, st, p, g)
+	}
+	st.BoundaryDistance, st.PreWarned = 0, false
+
+	if w.inGracePeriod(st, now) {
+		w.clearOutside(p.Id, now)
+		return action{}, false
+	}
+
+	punishAfter := w.c.PunishAfter(p.Role)
+	if vehicle {
+		punishAfter = w.c.VehiclePunishAfter(p.Role)
+	}
+	message := w.c.WarningMessage()
+	useBudget := w.c.EnforcementModel() == data.EnforcementBudget
+	// Players who never entered an allowed fence are handled according to the outside spawn policy of the server
+	if !st.Tracked {
+		switch w.c.OutsideSpawnPolicy() {
+		case data.OutsideSpawnGrace:
+			punishAfter = w.c.OutsideSpawnTime()
+			allow, _ := s.playerFences(p)
+			message = w.c.OutsideSpawnMessage(data.DescribeFences(allow))
+			useBudget = false
+		case data.OutsideSpawnEnforce:
+		default:
+			return action{}, false
+		}
+	}
+
+	o := st.Outside
+	if o == nil {
+		// Require the player to be outside for some consecutive polls, so that a single glitched position does not
+		// start the out-of-bounds period
+		if st.OutsideSamples++; st.OutsideSamples < w.c.OutsideSamples() {
+			w.diagnostics.UnconfirmedOutside.Add(1)
+			return action{}, false
+		}
+		o = &outsidePlayer{FirstOutside: now, Name: p.Name, PunishAfter: punishAfter}
+		if useBudget {
+			w.withBudget(st, p, o, now)
+		}
+		st.Outside = o
+		w.l.Info("player-outside-fence", "player", p.Name, "grid", g, "vehicle", vehicle)
+		w.schedulePunishment(ctx, p.Id, o.FirstOutside.Add(o.PunishAfter))
+	}
+	o.LastGrid = g
+	if o.Warned || now.Sub(o.FirstOutside) < o.WarnAfter {
+		return action{}, false
+	}
+	o.Warned = true
+
+	message = expandMessage(message, s, fences, p, g)
+	w.l.Debug("warning-message-final", "message", message)
+	return action{Kind: actionWarn, PlayerId: p.Id, PlayerName: p.Name, Grid: g, Message: message, Stale: o.Ended.Load}, true
+}
+
+// inside reports whether the player is considered inside the fences. Before their out-of-bounds period starts,
+// players are considered inside within the boundary tolerance outside the fences, e.g. when running along the
+// boundary. Once outside, players need to come back the return margin into the fences to be considered inside again.
+// Players in vehicles are considered inside within the vehicle buffer at any time.
+func (w *Worker) inside(s *session, fences *compiledFences, st *playerState, p api.GetPlayerResponse, cell data.Cell, vehicle bool) bool {
+	vehicleBuffer := 0.0
+	if vehicle {
+		vehicleBuffer = w.c.VehicleBuffer()
+	}
+	if fences.includes(cell) {
+		margin := w.c.ReturnMargin()
+		if st.Outside == nil || vehicleBuffer > 0 || margin == 0 || s.geometry == nil {
+			return true
+		}
+		_, depth, ok := s.geometry.Nearest(p.Position, outside{fences})
+		return !ok || depth/100 >= margin
+	}
+
+	tolerance := vehicleBuffer
+	if st.Outside == nil {
+		tolerance = max(tolerance, w.c.BoundaryTolerance())
+	}
+	if tolerance == 0 {
+		return false
+	}
+	d, _, ok := s.nearestAllowed(fences, p.Position)
+	return ok && d <= tolerance
+}
+
+// preWarning warns a player inside the fences who moved towards the boundary of the fences since the last
+// evaluation and is within the pre-warning distance of it. The player is warned again only after moving away from
+// the boundary.
+func (w *Worker) preWarning(s *session, fences *compiledFences, st *playerState, p api.GetPlayerResponse, g api.Grid) (action, bool) {
+	limit := w.c.PreWarningDistance()
+	if limit == 0 || s.geometry == nil {
+		return action{}, false
+	}
+	nearest, d, ok := s.geometry.Nearest(p.Position, outside{fences})
+	if !ok {
+		return action{}, false
+	}
+	d /= 100
+	approaching := st.BoundaryDistance != 0 && d < st.BoundaryDistance
+	st.BoundaryDistance = d
+	if d > limit {
+		st.PreWarned = false
+		return action{}, false
+	}
+	if st.PreWarned || !approaching {
+		return action{}, false
+	}
+	st.PreWarned = true
+	message := strings.NewReplacer(
+		"{grid}", g.String(),
+		"{distance}", strconv.Itoa(int(math.Ceil(d)))+"m",
+		"{direction}", data.Direction(p.Position, nearest),
+	).Replace(w.c.PreWarningMessage())
+	return action{Kind: actionPreWarn, PlayerId: p.Id, PlayerName: p.Name, Grid: g, Message: message}, true
+}
+
+// expandMessage replaces the placeholders of a warning message with the location of the player relative to the
+// allowed area. Distance and direction are left empty when the map is not known.
+func expandMessage(message string, s *session, fences *compiledFences, p api.GetPlayerResponse, g api.Grid) string {
+	var distance, direction string
+	if d, dir, ok := s.nearestAllowed(fences, p.Position); ok {
+		distance, direction = strconv.Itoa(int(math.Ceil(d)))+"m", dir
+	}
+	return strings.NewReplacer(
+		"{grid}", g.String(),
+		"{distance}", distance,
+		"{direction}", direction,
+	).Replace(message)
+}
+
+// updateSpawn records the time when the player spawned, e.g. after a redeploy or death.
+func updateSpawn(st *playerState, p api.GetPlayerResponse, now time.Time) {
+	spawned := p.Position.IsSpawned()
+	if st.Spawn.Known && st.Spawn.Spawned == spawned {
+		return
+	}
+	// The first observation of a spawned player is not a spawn, as it is not known when the player spawned
+	at := st.Spawn.At
+	if spawned && st.Spawn.Known {
+		at = now
+	}
+	st.Spawn = spawn{Known: true, Spawned: spawned, At: at}
+}
+
+// acceptPosition reports whether the position of the player is plausible, based on the last accepted position.
+// Positions the player could not have moved to since then are discarded, unless the player spawned in between. A
+// discarded position is accepted after all, when the next position is plausible based on it, as the player then
+// really moved there, e.g. with a redeploy between two polls.
+func (w *Worker) acceptPosition(st *playerState, p api.GetPlayerResponse, now time.Time) bool {
+	m := st.Movement
+	if m.At.IsZero() || st.Spawn.At.After(m.At) || w.plausible(sample{m.Position, m.At}, p.Position, now) {
+		st.Discarded = nil
+		return true
+	}
+	if d := st.Discarded; d != nil && w.plausible(*d, p.Position, now) {
+		st.Discarded = nil
+		return true
+	}
+	st.Discarded = &sample{Position: p.Position, At: now}
+	return false
+}
+
+// plausible reports whether a player can move from the sample to the position until the given time.
+func (w *Worker) plausible(from sample, to api.WorldPosition, now time.Time) bool {
+	elapsed := now.Sub(from.At).Seconds()
+	if elapsed <= 0 {
+		return true
+	}
+	return to.Distance(from.Position).Meters()/elapsed <= w.c.MaxPlausibleSpeed()
+}
+
+// updateMovement estimates the speed of the player from the distance moved since the last evaluation. Movements
+// across a respawn or faster than a player could move are ignored, as they are teleports.
+func (w *Worker) updateMovement(st *playerState, p api.GetPlayerResponse, now time.Time) {
+	m := &st.Movement
+	if m.At.IsZero() || st.Spawn.At.After(m.At) || !w.plausible(sample{m.Position, m.At}, p.Position, now) {
+		m.Speed = 0
+	} else if elapsed := now.Sub(m.At).Seconds(); elapsed > 0 {
+		m.Speed = (m.Speed + p.Position.Distance(m.Position).Meters()/elapsed) / 2
+	}
+	m.Position, m.At = p.Position, now
+}
+
+// inGracePeriod reports whether the out-of-bounds time of the player is currently not counted.
+func (w *Worker) inGracePeriod(st *playerState, now time.Time) bool {
+	if !st.Spawn.At.IsZero() && now.Sub(st.Spawn.At) < w.c.SpawnGrace() {
+		return true
+	}
+	if now.Sub(w.matchStartedAt.Load()) < w.c.MatchStartGrace() {
+		return true
+	}
+	return now.Sub(w.fencesChangedAt.Load()) < w.c.FenceChangeGrace()
+}
+
+// withBudget applies the budget enforcement model to a player who just left the fences. The out-of-bounds time the
+// player already used in the current match is considered by moving the start of the out-of-bounds period back.
+func (w *Worker) withBudget(st *playerState, p api.GetPlayerResponse, o *outsidePlayer, now time.Time) {
+	o.Budget = true
+	o.PunishAfter = w.c.Budget(p.Role)
+	o.WarnAfter = w.c.BudgetWarnAt()
+	if b := st.Budget; b != nil {
+		if used := b.Used - w.c.BudgetDecay(now.Sub(b.Since)); used > 0 {
+			o.FirstOutside = o.FirstOutside.Add(-used)
+			o.Warned = b.Warned
+		}
+	}
+}
+
+func (w *Worker) isWhitelisted(p api.GetPlayerResponse, now time.Time) bool {
+	var additional []data.WhitelistEntry
+	if w.whitelist != nil {
+		additional = w.whitelist.Entries()
+	}
+	_, ok := w.c.WhitelistEntry(p, now, additional...)
+	return ok
+}
+
+func (w *Worker) isExempted(p api.GetPlayerResponse, now time.Time) bool {
+	until, ok := w.exemptions.Load(p.Id)
+	if ok && !now.Before(until) {
+		w.exemptions.Delete(p.Id)
+		return false
+	}
+	return ok
+}
+
+// clearOutside ends the out-of-bounds period of the player, if any. It must only be called from the evaluation loop.
+func (w *Worker) clearOutside(id string, now time.Time) {
+	if st, ok := w.players[id]; ok {
+		st.endOutside(now)
+	}
+	w.punishments.Cancel(id)
+}
+
+// resetOutsidePlayers ends the out-of-bounds period of all players. It must only be called from the evaluation loop.
+func (w *Worker) resetOutsidePlayers() {
+	now := time.Now()
+	for id := range w.players {
+		w.clearOutside(id, now)
+	}
+}
+
+// resetMatch drops the state of all players which is bound to a match. It must only be called from the evaluation
+// loop.
+func (w *Worker) resetMatch() {
+	now := time.Now()
+	for id, st := range w.players {
+		w.clearOutside(id, now)
+		st.Tracked = false
+		st.Budget = nil
+	}
+}
+
+// forgetPlayer drops all enforcement state of the player. It must only be called from the evaluation loop.
+func (w *Worker) forgetPlayer(id string) {
+	w.clearOutside(id, time.Now())
+	delete(w.players, id)
+}
@@ -0,0 +1,503 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var carentan = &api.GetSessionResponse{MapName: "CARENTAN", GameMode: "Warfare", PlayerCount: 10}
+
+// position returns the world position of the center of the given grid numpad.
+func position(si *api.GetSessionResponse, g api.Grid) api.WorldPosition {
+	size := si.GridSize()
+	x := float64(slices.Index([]string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"}, g.X) - 5)
+	y := float64(g.Y - 6)
+	col := float64((g.Numpad - 1) % 3)
+	row := float64(2 - (g.Numpad-1)/3)
+	return api.WorldPosition{
+		X: x*size + (col+0.5)*size/3,
+		Y: y*size + (row+0.5)*size/3,
+	}
+}
+
+func player(id string, g api.Grid) api.GetPlayerResponse {
+	return api.GetPlayerResponse{Id: id, Name: "player-" + id, Team: api.PlayerTeamUs, Role: api.PlayerRoleRifleman, Position: position(carentan, g)}
+}
+
+// at returns player 1 in row 5 the given distance in metres east of the eastern boundary of column E, or west of it
+// for negative distances.
+func at(meters float64) api.GetPlayerResponse {
+	p := player("1", api.Grid{X: "E", Y: 5, Numpad: 5})
+	p.Position.X = meters * 100
+	return p
+}
+
+func newTestWorker(c data.Server) *Worker {
+	w := NewWorker(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, c)
+	w.updateSession(carentan)
+	return w
+}
+
+// runControl runs the functions passed to do, as the evaluation loop would.
+func runControl(w *Worker) {
+	select {
+	case f := <-w.control:
+		f()
+	default:
+	}
+}
+
+var _ = Describe("evaluate", func() {
+	var w *Worker
+	var c data.Server
+	ctx := context.Background()
+	now := time.Now()
+	inside := api.Grid{X: "E", Y: 5, Numpad: 5}
+	outside := api.Grid{X: "A", Y: 5, Numpad: 5}
+
+	BeforeEach(func() {
+		c = data.Server{
+			AlliesFence: []data.Fence{{X: Pointer("E")}},
+			// Players in tests jump between grids far apart, which would be discarded as implausible otherwise
+			Positions: &data.Positions{MaxSpeedMetersPerSecond: Pointer(1000.0)},
+		}
+	})
+
+	JustBeforeEach(func() {
+		w = newTestWorker(c)
+	})
+
+	AfterEach(func() {
+		w.punishments.Stop()
+	})
+
+	evaluate := func(at time.Time, players ...api.GetPlayerResponse) []action {
+		return w.evaluate(ctx, w.session.Load(), players, at)
+	}
+
+	It("does not warn players inside the fences", func() {
+		Expect(evaluate(now, player("1", inside))).To(BeEmpty())
+		Expect(w.players["1"].Tracked).To(BeTrue())
+	})
+
+	It("ignores players who never entered the fences", func() {
+		Expect(evaluate(now, player("1", outside))).To(BeEmpty())
+	})
+
+	It("warns tracked players once when leaving the fences", func() {
+		evaluate(now, player("1", inside))
+
+		a := evaluate(now.Add(time.Second), player("1", outside))
+		Expect(a).To(HaveLen(1))
+		Expect(a[0].Kind).To(Equal(actionWarn))
+		Expect(a[0].Grid).To(Equal(outside))
+		Expect(evaluate(now.Add(2*time.Second), player("1", outside))).To(BeEmpty())
+		Expect(w.outside.Load()).To(Equal(1))
+
+		deadline, ok := w.punishments.Deadline("1")
+		Expect(ok).To(BeTrue())
+		Expect(deadline).To(Equal(now.Add(11 * time.Second)))
+	})
+
+	It("tells players where the allowed area is", func() {
+		c.Messages = &data.Messages{Warning: Pointer("{grid}: go back {distance} {direction}")}
+		w = newTestWorker(c)
+		evaluate(now, player("1", inside))
+
+		// The center of the numpad is half a numpad, 33.6m, away from the fence in column E
+		a := evaluate(now, player("1", api.Grid{X: "F", Y: 5, Numpad: 4}))
+		Expect(a).To(HaveLen(1))
+		Expect(a[0].Message).To(Equal("F5 Numpad 4: go back 34m west"))
+	})
+
+	Context("with vehicles", func() {
+		BeforeEach(func() {
+			c.Vehicles = &data.Vehicles{SpeedMetersPerSecond: Pointer(8.0), Roles: []string{"Tank Commander"}, PunishAfterSeconds: Pointer(30), BufferMeters: Pointer(20.0)}
+			c.Positions = nil
+		})
+
+		It("estimates the speed of players", func() {
+			evaluate(now, at(-100))
+			evaluate(now.Add(time.Second), at(-80))
+			Expect(w.players["1"].Movement.Speed).To(BeNumerically("~", 10))
+		})
+
+		It("ignores teleports", func() {
+			evaluate(now, at(-1000))
+			evaluate(now.Add(time.Second), at(-100))
+			Expect(w.players["1"].Movement.Speed).To(BeZero())
+		})
+
+		It("ignores movements across a respawn", func() {
+			evaluate(now, at(-100))
+			dead := at(0)
+			dead.Position = api.WorldPosition{}
+			evaluate(now.Add(time.Second), dead)
+			evaluate(now.Add(2*time.Second), at(-80))
+			Expect(w.players["1"].Movement.Speed).To(BeZero())
+		})
+
+		It("applies the vehicle punish delay to fast players", func() {
+			evaluate(now, at(-50))
+			evaluate(now.Add(time.Second), at(-30))
+			evaluate(now.Add(2*time.Second), at(30))
+
+			deadline, ok := w.punishments.Deadline("1")
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(Equal(now.Add(32 * time.Second)))
+		})
+
+		It("considers crews close to the fences inside", func() {
+			p := at(-100)
+			p.Role = api.PlayerRoleTankCommander
+			evaluate(now, p)
+			p.Position.X = 1500
+
+			Expect(evaluate(now, p)).To(BeEmpty())
+			Expect(w.players["1"].Outside).To(BeNil())
+		})
+
+		It("does not consider slow infantry close to the fences inside", func() {
+			evaluate(now, at(-100))
+			Expect(evaluate(now, at(15))).To(HaveLen(1))
+		})
+	})
+
+	Context("with position filtering", func() {
+		BeforeEach(func() {
+			c.Positions = &data.Positions{OutsideSamples: Pointer(2)}
+		})
+
+		It("discards impossible jumps", func() {
+			evaluate(now, at(-10))
+			Expect(evaluate(now.Add(time.Second), at(800))).To(BeEmpty())
+			Expect(w.players["1"].OutsideSamples).To(BeZero())
+			Expect(w.diagnostics.DiscardedSamples.Load()).To(BeEquivalentTo(1))
+		})
+
+		It("discards positions outside the map", func() {
+			evaluate(now, at(-10))
+			Expect(evaluate(now, at(5000))).To(BeEmpty())
+			Expect(w.diagnostics.DiscardedSamples.Load()).To(BeEquivalentTo(1))
+		})
+
+		It("accepts jumps after a respawn", func() {
+			evaluate(now, at(-10))
+			dead := at(0)
+			dead.Position = api.WorldPosition{}
+			evaluate(now.Add(time.Second), dead)
+			evaluate(now.Add(2*time.Second), at(800))
+
+			Expect(w.players["1"].OutsideSamples).To(Equal(1))
+			Expect(w.diagnostics.DiscardedSamples.Load()).To(BeZero())
+		})
+
+		It("accepts jumps confirmed by the next position", func() {
+			evaluate(now, at(-10))
+			evaluate(now.Add(time.Second), at(800))
+			evaluate(now.Add(2*time.Second), at(810))
+
+			Expect(w.players["1"].OutsideSamples).To(Equal(1))
+		})
+
+		It("starts the out-of-bounds period after consecutive outside positions", func() {
+			evaluate(now, at(-10))
+			Expect(evaluate(now.Add(time.Second), at(10))).To(BeEmpty())
+			Expect(w.players["1"].Outside).To(BeNil())
+			Expect(w.diagnostics.UnconfirmedOutside.Load()).To(BeEquivalentTo(1))
+
+			Expect(evaluate(now.Add(2*time.Second), at(20))).To(HaveLen(1))
+			Expect(w.players["1"].Outside).ToNot(BeNil())
+		})
+
+		It("requires consecutive outside positions", func() {
+			evaluate(now, at(-10))
+			evaluate(now.Add(time.Second), at(10))
+			evaluate(now.Add(2*time.Second), at(-10))
+			Expect(evaluate(now.Add(3*time.Second), at(10))).To(BeEmpty())
+		})
+
+		It("ends the out-of-bounds period when the player is not on the map", func() {
+			evaluate(now, at(-10))
+			evaluate(now.Add(time.Second), at(10))
+			evaluate(now.Add(2*time.Second), at(20))
+			dead := at(0)
+			dead.Position = api.WorldPosition{}
+			evaluate(now.Add(3*time.Second), dead)
+
+			Expect(w.players["1"].Outside).To(BeNil())
+			Expect(w.players["1"].OutsideSamples).To(BeZero())
+		})
+	})
+
+	Context("with a boundary buffer", func() {
+		BeforeEach(func() {
+			c.Buffer = &data.Buffer{ToleranceMeters: Pointer(10.0), ReturnMeters: Pointer(15.0)}
+			c.Positions = nil
+		})
+
+		It("tolerates players just outside the boundary", func() {
+			evaluate(now, at(-5))
+			Expect(evaluate(now.Add(time.Second), at(5))).To(BeEmpty())
+			Expect(w.players["1"].Outside).To(BeNil())
+
+			Expect(evaluate(now.Add(2*time.Second), at(15))).To(HaveLen(1))
+		})
+
+		It("requires players to come back some distance into the fences", func() {
+			evaluate(now, at(-5))
+			evaluate(now.Add(time.Second), at(15))
+			Expect(evaluate(now.Add(2*time.Second), at(5))).To(BeEmpty())
+			Expect(w.players["1"].Outside).ToNot(BeNil())
+
+			evaluate(now.Add(3*time.Second), at(-10))
+			Expect(w.players["1"].Outside).ToNot(BeNil())
+
+			evaluate(now.Add(4*time.Second), at(-20))
+			Expect(w.players["1"].Outside).To(BeNil())
+		})
+
+		It("does not warn players flickering along the boundary repeatedly", func() {
+			evaluate(now, at(-5))
+			var warnings int
+			for i, x := range []float64{12, -3, 12, -3, 12} {
+				warnings += len(evaluate(now.Add(time.Duration(i+1)*time.Second), at(x)))
+			}
+			Expect(warnings).To(Equal(1))
+		})
+	})
+
+	Context("with pre-warnings", func() {
+		BeforeEach(func() {
+			c.PreWarning = &data.PreWarning{DistanceMeters: Pointer(50), Message: Pointer("{distance} {direction}")}
+		})
+
+		It("warns players approaching the boundary once", func() {
+			Expect(evaluate(now, at(-100))).To(BeEmpty())
+			Expect(evaluate(now, at(-60))).To(BeEmpty())
+
+			a := evaluate(now, at(-40))
+			Expect(a).To(HaveLen(1))
+			Expect(a[0].Kind).To(Equal(actionPreWarn))
+			Expect(a[0].Message).To(Equal("40m east"))
+			Expect(evaluate(now, at(-30))).To(BeEmpty())
+		})
+
+		It("does not warn players moving away from the boundary", func() {
+			evaluate(now, at(-20))
+			Expect(evaluate(now, at(-30))).To(BeEmpty())
+		})
+
+		It("warns again after moving away from the boundary", func() {
+			evaluate(now, at(-60))
+			Expect(evaluate(now, at(-40))).To(HaveLen(1))
+			evaluate(now, at(-60))
+			Expect(evaluate(now, at(-40))).To(HaveLen(1))
+		})
+	})
+
+	It("cancels the punishment when returning to the fences", func() {
+		evaluate(now, player("1", inside))
+		evaluate(now, player("1", outside))
+		evaluate(now, player("1", inside))
+
+		_, ok := w.punishments.Deadline("1")
+		Expect(ok).To(BeFalse())
+		Expect(w.players["1"].Outside).To(BeNil())
+	})
+
+	It("marks pending warnings stale when returning to the fences", func() {
+		evaluate(now, player("1", inside))
+		a := evaluate(now, player("1", outside))
+		Expect(a).To(HaveLen(1))
+		Expect(a[0].Stale()).To(BeFalse())
+
+		evaluate(now, player("1", inside))
+		Expect(a[0].Stale()).To(BeTrue())
+	})
+
+	It("polls more often while players are next to the boundary", func() {
+		evaluate(now, player("1", inside))
+		Expect(w.playerInterval()).To(Equal(c.PlayersInterval()))
+
+		c.Polling = &data.Polling{BoundaryMilliseconds: Pointer(100)}
+		w.c = c
+		evaluate(now, player("1", api.Grid{X: "E", Y: 5, Numpad: 4}))
+		Expect(w.playerInterval()).To(Equal(100 * time.Millisecond))
+	})
+
+	It("forgets players that left the server", func() {
+		renamed := player("1", inside)
+		renamed.Name = "renamed-1"
+		evaluate(now, player("1", inside), player("2", inside))
+		evaluate(now, renamed, player("2", inside))
+		evaluate(now, player("2", inside))
+
+		Expect(w.players).ToNot(HaveKey("1"))
+		_, ok := w.playerIds.Load("player-1")
+		Expect(ok).To(BeFalse())
+		_, ok = w.playerIds.Load("renamed-1")
+		Expect(ok).To(BeFalse())
+		id, _ := w.playerIds.Load("player-2")
+		Expect(id).To(Equal("2"))
+	})
+
+	Context("with roles", func() {
+		BeforeEach(func() {
+			c.AlliesFence[0].ExceptRoles = []string{"Crewman"}
+			c.Roles = map[string]data.RoleRule{"ArmyCommander": {Exempt: true}}
+		})
+
+		It("does not warn exempted roles", func() {
+			p := player("1", inside)
+			p.Role = api.PlayerRoleArmyCommander
+			evaluate(now, p)
+			p.Position = position(carentan, outside)
+
+			Expect(evaluate(now, p)).To(BeEmpty())
+		})
+
+		It("does not warn roles without fences", func() {
+			p := player("1", inside)
+			p.Role = api.PlayerRoleCrewman
+			evaluate(now, p)
+			p.Position = position(carentan, outside)
+
+			Expect(evaluate(now, p)).To(BeEmpty())
+		})
+	})
+
+	Context("with deny fences", func() {
+		BeforeEach(func() {
+			c.AlliesDenyFence = []data.Fence{{X: Pointer("E"), Y: Pointer(5)}}
+		})
+
+		It("warns players inside a deny fence", func() {
+			evaluate(now, player("1", api.Grid{X: "E", Y: 4, Numpad: 5}))
+
+			Expect(evaluate(now, player("1", inside))).To(HaveLen(1))
+		})
+	})
+
+	Context("with sub numpads", func() {
+		BeforeEach(func() {
+			c.AlliesDenyFence = []data.Fence{{X: Pointer("E"), Y: Pointer(5), Numpads: []int{5}, SubNumpads: []int{5}}}
+		})
+
+		It("warns players only inside the sub numpad", func() {
+			// The center of the numpad is in its sub numpad 5, one sub numpad further south-west is sub numpad 1
+			p := player("1", inside)
+			p.Position.X -= carentan.GridSize() / 9
+			p.Position.Y += carentan.GridSize() / 9
+			Expect(evaluate(now, p)).To(BeEmpty())
+			Expect(w.players["1"].Tracked).To(BeTrue())
+
+			a := evaluate(now.Add(time.Second), player("1", inside))
+			Expect(a).To(HaveLen(1))
+			Expect(a[0].Grid).To(Equal(inside))
+		})
+	})
+
+	Context("with outside spawn grace", func() {
+		BeforeEach(func() {
+			c.OutsideSpawn = &data.OutsideSpawn{Policy: data.OutsideSpawnGrace, Seconds: Pointer(30)}
+		})
+
+		It("gives players time to reach the fences", func() {
+			a := evaluate(now, player("1", outside))
+
+			Expect(a).To(HaveLen(1))
+			Expect(a[0].Message).To(ContainSubstring("E"))
+			deadline, _ := w.punishments.Deadline("1")
+			Expect(deadline).To(Equal(now.Add(30 * time.Second)))
+		})
+	})
+
+	Context("with spawn grace", func() {
+		BeforeEach(func() {
+			c.Grace = &data.Grace{AfterSpawnSeconds: Pointer(5)}
+		})
+
+		It("does not count the time after spawning", func() {
+			evaluate(now, player("1", inside))
+			dead := player("1", inside)
+			dead.Position = api.WorldPosition{}
+			evaluate(now, dead)
+
+			Expect(evaluate(time.Now(), player("1", outside))).To(BeEmpty())
+		})
+	})
+
+	Context("with budget enforcement", func() {
+		BeforeEach(func() {
+			c.Enforcement = &data.Enforcement{Model: data.EnforcementBudget, BudgetSeconds: Pointer(10)}
+		})
+
+		It("accumulates the out-of-bounds time", func() {
+			evaluate(now, player("1", inside))
+			evaluate(now, player("1", outside))
+			evaluate(now.Add(4*time.Second), player("1", inside))
+
+			Expect(evaluate(now.Add(5*time.Second), player("1", outside))).To(BeEmpty())
+			deadline, _ := w.punishments.Deadline("1")
+			Expect(deadline).To(Equal(now.Add(11 * time.Second)))
+		})
+
+		It("keeps the out-of-bounds time of players who died outside", func() {
+			evaluate(now, player("1", inside))
+			evaluate(now, player("1", outside))
+			dead := player("1", outside)
+			dead.Position = api.WorldPosition{}
+			evaluate(now.Add(4*time.Second), dead)
+
+			evaluate(now.Add(5*time.Second), player("1", outside))
+			deadline, _ := w.punishments.Deadline("1")
+			Expect(deadline).To(Equal(now.Add(11 * time.Second)))
+		})
+
+		It("keeps the out-of-bounds time of players whose grace period started outside", func() {
+			w.c.Grace = &data.Grace{AfterFenceChangeSeconds: Pointer(1)}
+			w.fencesChangedAt.Store(time.Time{})
+			evaluate(now, player("1", inside))
+			evaluate(now, player("1", outside))
+			w.fencesChangedAt.Store(now.Add(4 * time.Second))
+			evaluate(now.Add(4*time.Second), player("1", outside))
+			_, ok := w.punishments.Deadline("1")
+			Expect(ok).To(BeFalse())
+
+			evaluate(now.Add(5*time.Second), player("1", outside))
+			deadline, _ := w.punishments.Deadline("1")
+			Expect(deadline).To(Equal(now.Add(11 * time.Second)))
+		})
+	})
+
+	Context("with punishments", func() {
+		BeforeEach(func() {
+			c.PunishAfterSeconds = Pointer(0)
+		})
+
+		It("punishes players once the deadline passed", func() {
+			evaluate(now, player("1", inside))
+			evaluate(now, player("1", outside))
+
+			Eventually(func() int {
+				runControl(w)
+				return w.queue.Len(priorityPunish)
+			}).Should(Equal(1))
+			punish, _, _ := w.queue.next(time.Now(), false)
+			Expect(punish.Target).To(Equal("player-1"))
+		})
+	})
+})
+
+func Pointer[T any](v T) *T {
+	return &v
+}
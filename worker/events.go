@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/adminlog"
+)
+
+// logMargin is the time the admin log is polled for in addition to the poll interval, so that entries are not missed
+// when polls are delayed, e.g. by a slow connection.
+const logMargin = 30 * time.Second
+
+// logWindow returns the time in seconds to poll the admin log for, with polls every interval.
+func logWindow(interval time.Duration) int32 {
+	return int32((interval + logMargin + time.Second - 1) / time.Second)
+}
+
+// pollLog tails the admin log of the server and reacts to the events in it.
+func (w *Worker) pollLog(ctx context.Context) {
+	interval := w.c.AdminLogInterval()
+	t := time.NewTicker(interval)
+	tail := adminlog.NewTail()
+	lastCommand := map[string]time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+			var entries []api.AdminLogEntry
+			err := w.rcon(ctx, priorityPoll, "admin-log", func(c connection) error {
+				l, err := c.AdminLog(ctx, logWindow(interval), "")
+				if err != nil {
+					return err
+				}
+				entries = l.Entries
+				return nil
+			})
+			if err != nil {
+				w.l.Error("poll-log", "error", err)
+				continue
+			}
+			for _, e := range tail.Events(entries) {
+				switch ev := e.Event.(type) {
+				case adminlog.Chat:
+					w.handleCommand(ctx, ev, lastCommand)
+				default:
+					w.handleEvent(ctx, ev)
+				}
+			}
+		}
+	}
+}
+
+func (w *Worker) handleEvent(ctx context.Context, e adminlog.Event) {
+	switch ev := e.(type) {
+	case adminlog.MatchStart:
+		w.l.Info("match-start", "map", ev.MapName, "game_mode", ev.GameMode)
+		w.matchStartedAt.Store(time.Now())
+		w.do(w.resetMatch)
+		if err := w.refreshSession(ctx); err != nil {
+			w.l.Error("match-start-session", "error", err)
+		}
+	case adminlog.MatchEnded:
+		w.l.Info("match-ended", "map", ev.MapName, "game_mode", ev.GameMode, "allied_score", ev.AlliedScore, "axis_score", ev.AxisScore)
+	case adminlog.Connected:
+		w.playerIds.Store(ev.PlayerName, ev.PlayerId)
+	case adminlog.Disconnected:
+		w.playerIds.Delete(ev.PlayerName)
+		w.do(func() { w.forgetPlayer(ev.PlayerId) })
+	case adminlog.TeamSwitch:
+		if id, ok := w.playerIds.Load(ev.PlayerName); ok {
+			w.l.Debug("player-switched-team", "player", ev.PlayerName, "from", ev.From, "to", ev.To)
+			w.do(func() { w.forgetPlayer(id) })
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/adminlog"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("handleEvent", func() {
+	var w *Worker
+	var server *fakeServer
+	var cancel context.CancelFunc
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		w = newTestWorker(data.Server{AlliesFence: []data.Fence{{X: Pointer("E")}}})
+		server = newFakeServer(&api.GetSessionResponse{MapName: "CARENTAN", GameMode: "Warfare", PlayerCount: 30})
+		var runCtx context.Context
+		runCtx, cancel = context.WithCancel(ctx)
+		runWorker(runCtx, w, server)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// outside puts the player outside of the fences with a pending punishment, as evaluate would.
+	outside := func(id string) {
+		inLoop(w, func() {
+			w.players[id] = &playerState{
+				Tracked: true,
+				Budget:  &budget{Used: time.Minute},
+				Outside: &outsidePlayer{FirstOutside: time.Now()},
+			}
+			w.schedulePunishment(ctx, id, time.Now().Add(time.Hour))
+		})
+	}
+	state := func(id string) (st playerState, ok bool) {
+		inLoop(w, func() {
+			var p *playerState
+			if p, ok = w.players[id]; ok {
+				st = playerState{Tracked: p.Tracked, Budget: p.Budget, Outside: p.Outside}
+			}
+		})
+		return
+	}
+	scheduled := func(id string) bool {
+		_, ok := w.punishments.Deadline(id)
+		return ok
+	}
+
+	It("resets the players and refreshes the session when a match starts", func() {
+		outside("1")
+		outside("2")
+		before := time.Now()
+
+		w.handleEvent(ctx, adminlog.MatchStart{MapName: "CARENTAN", GameMode: "Warfare"})
+
+		for _, id := range []string{"1", "2"} {
+			st, ok := state(id)
+			Expect(ok).To(BeTrue())
+			Expect(st.Tracked).To(BeFalse())
+			Expect(st.Outside).To(BeNil())
+			Expect(st.Budget).To(BeNil())
+			Expect(scheduled(id)).To(BeFalse())
+		}
+		Expect(w.matchStartedAt.Load()).To(BeTemporally(">=", before))
+		Expect(w.session.Load().info.PlayerCount).To(Equal(30))
+	})
+
+	It("drops the state of players who disconnected", func() {
+		w.handleEvent(ctx, adminlog.Connected{PlayerName: "player-1", PlayerId: "1"})
+		outside("1")
+		outside("2")
+
+		w.handleEvent(ctx, adminlog.Disconnected{PlayerName: "player-1", PlayerId: "1"})
+
+		_, ok := state("1")
+		Expect(ok).To(BeFalse())
+		Expect(scheduled("1")).To(BeFalse())
+		_, ok = w.playerIds.Load("player-1")
+		Expect(ok).To(BeFalse())
+
+		_, ok = state("2")
+		Expect(ok).To(BeTrue())
+		Expect(scheduled("2")).To(BeTrue())
+	})
+
+	It("starts tracking players over after they switched teams", func() {
+		w.handleEvent(ctx, adminlog.Connected{PlayerName: "player-1", PlayerId: "1"})
+		outside("1")
+		outside("2")
+
+		w.handleEvent(ctx, adminlog.TeamSwitch{PlayerName: "player-1", From: "Allies", To: "Axis"})
+
+		_, ok := state("1")
+		Expect(ok).To(BeFalse())
+		Expect(scheduled("1")).To(BeFalse())
+		_, ok = state("2")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("ignores team switches of unknown players", func() {
+		outside("1")
+
+		w.handleEvent(ctx, adminlog.TeamSwitch{PlayerName: "player-1", From: "Allies", To: "Axis"})
+
+		st, ok := state("1")
+		Expect(ok).To(BeTrue())
+		Expect(st.Outside).ToNot(BeNil())
+		Expect(scheduled("1")).To(BeTrue())
+	})
+})
+
+var _ = Describe("pollLog", func() {
+	var w *Worker
+	var server *fakeServer
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		w = newTestWorker(data.Server{
+			AlliesFence: []data.Fence{{X: Pointer("E")}},
+			Commands:    &data.Commands{Admins: []string{"76561198025480905"}},
+			Polling:     &data.Polling{AdminLogMilliseconds: Pointer(10)},
+		})
+		server = newFakeServer(carentan)
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		runWorker(ctx, w, server)
+		go w.pollLog(ctx)
+		Eventually(server.LogPolls).Should(BeNumerically(">=", 1))
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("runs commands of admins", func() {
+		server.log("[355 ms (1743938197)] CHAT[Team][Toasty(Allies/76561198025480905)]: !seed off")
+
+		Eventually(w.disabled.Load).Should(BeTrue())
+	})
+
+	It("refuses commands of players who pretend to be an admin", func() {
+		server.log("[355 ms (1743938197)] CHAT[Team][Griefer(Allies/76561198000000001)]: x(Allies/76561198025480905)]: !seed off")
+		polls := server.LogPolls()
+
+		Eventually(server.LogPolls).Should(BeNumerically(">", polls+2))
+		Expect(w.disabled.Load()).To(BeFalse())
+		Expect(server.Messages()).To(BeEmpty())
+	})
+})
+
+var _ = DescribeTable("logWindow",
+	func(interval time.Duration, seconds int) {
+		Expect(logWindow(interval)).To(BeEquivalentTo(seconds))
+	},
+	Entry("default interval", 2*time.Second, 32),
+	Entry("fractions of seconds", 1500*time.Millisecond, 32),
+	Entry("intervals longer than the margin", time.Minute, 90),
+)
@@ -0,0 +1,252 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	gosync "sync"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2"
+)
+
+// priority of a command in the command queue. Commands with a lower priority value run first.
+type priority int
+
+const (
+	priorityPoll priority = iota
+	priorityPunish
+	priorityWarn
+	priorityBroadcast
+	priorityCount
+)
+
+func (p priority) String() string {
+	switch p {
+	case priorityPoll:
+		return "poll"
+	case priorityPunish:
+		return "punish"
+	case priorityWarn:
+		return "warn"
+	case priorityBroadcast:
+		return "broadcast"
+	}
+	return "unknown"
+}
+
+var errStaleCommand = errors.New("command is stale")
+
+// command is a single command run against the server by the command queue.
+type command struct {
+	Priority priority
+	Name     string
+	// Target is the player the command is about, if any. It is only used for logging.
+	Target string
+	Run    func(ctx context.Context) error
+	// Stale reports whether the command is not needed anymore, e.g. because the player already returned to the fences.
+	Stale func() bool
+	// Expires is the time after which the command is dropped instead of being run. Zero means the command does not
+	// expire.
+	Expires time.Time
+	// Done is called with the final result of the command, after all retries.
+	Done     func(err error)
+	attempts int
+}
+
+func (c *command) stale(now time.Time) bool {
+	return (!c.Expires.IsZero() && now.After(c.Expires)) || (c.Stale != nil && c.Stale())
+}
+
+func (c *command) done(err error) {
+	if c.Done != nil {
+		c.Done(err)
+	}
+}
+
+type queueOptions struct {
+	// Workers is the number of commands run concurrently, at least two. One of them only runs polls, so that polls are
+	// never delayed by slow actions.
+	Workers int
+	// Limits are the rate limits of each priority.
+	Limits [priorityCount]rateLimit
+	// MaxPending is the maximum number of pending commands of a priority other than polls, further commands are
+	// dropped.
+	MaxPending int
+	// MaxAttempts is the number of times a command other than a poll is run when it fails with a transient error.
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+var defaultQueueOptions = queueOptions{
+	Workers: actionWorkers,
+	Limits: [priorityCount]rateLimit{
+		priorityPunish:    {Interval: 100 * time.Millisecond, Burst: 10},
+		priorityWarn:      {Interval: 200 * time.Millisecond, Burst: 10},
+		priorityBroadcast: {Interval: time.Second, Burst: 2},
+	},
+	MaxPending:  100,
+	MaxAttempts: 3,
+	RetryDelay:  time.Second,
+}
+
+// commandQueue runs the commands of a server by priority, with a rate limit per priority. Commands which fail with
+// a transient error are retried, commands which became stale while waiting are dropped.
+type commandQueue struct {
+	mu       gosync.Mutex
+	o        queueOptions
+	l        *slog.Logger
+	pending  [priorityCount][]*command
+	limiters [priorityCount]limiter
+	// changed is closed and replaced whenever a command is added.
+	changed chan struct{}
+}
+
+func newCommandQueue(l *slog.Logger, o queueOptions) *commandQueue {
+	q := &commandQueue{
+		o:       o,
+		l:       l,
+		changed: make(chan struct{}),
+	}
+	for p := range q.limiters {
+		q.limiters[p] = limiter{rateLimit: o.Limits[p]}
+	}
+	return q
+}
+
+// Push adds the command to the queue. Commands other than polls are dropped when too many of the same priority are
+// pending already.
+func (q *commandQueue) Push(c *command) {
+	q.mu.Lock()
+	if c.Priority != priorityPoll && len(q.pending[c.Priority]) >= q.o.MaxPending {
+		q.mu.Unlock()
+		q.l.Warn("command-queue-full", "command", c.Name, "priority", c.Priority, "target", c.Target)
+		c.done(errors.New("command queue full"))
+		return
+	}
+	q.pending[c.Priority] = append(q.pending[c.Priority], c)
+	close(q.changed)
+	q.changed = make(chan struct{})
+	q.mu.Unlock()
+}
+
+// Run runs the commands of the queue until the context is done.
+func (q *commandQueue) Run(ctx context.Context) {
+	for i := range max(q.o.Workers, 2) {
+		go q.work(ctx, i == 0)
+	}
+}
+
+func (q *commandQueue) work(ctx context.Context, pollsOnly bool) {
+	for {
+		c, wait, changed := q.next(time.Now(), pollsOnly)
+		if c != nil {
+			q.run(ctx, c)
+			continue
+		}
+		var timeout <-chan time.Time
+		var t *time.Timer
+		if wait > 0 {
+			t = time.NewTimer(wait)
+			timeout = t.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-timeout:
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next takes the next command to run. Stale commands are dropped on the way. When no command can run right now, it
+// returns the time until a rate limited command can run, if any, and a channel closed when a command is added.
+func (q *commandQueue) next(now time.Time, pollsOnly bool) (*command, time.Duration, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var wait time.Duration
+	for p := range q.pending {
+		if pollsOnly && priority(p) != priorityPoll {
+			break
+		}
+		for len(q.pending[p]) != 0 {
+			c := q.pending[p][0]
+			if c.stale(now) {
+				q.pending[p] = q.pending[p][1:]
+				q.l.Debug("command-dropped-stale", "command", c.Name, "target", c.Target)
+				c.done(errStaleCommand)
+				continue
+			}
+			if d := q.limiters[p].take(now); d > 0 {
+				if wait == 0 || d < wait {
+					wait = d
+				}
+				break
+			}
+			q.pending[p] = q.pending[p][1:]
+			return c, 0, nil
+		}
+	}
+	return nil, wait, q.changed
+}
+
+func (q *commandQueue) run(ctx context.Context, c *command) {
+	c.attempts++
+	err := c.Run(ctx)
+	if err != nil && c.Priority != priorityPoll && c.attempts < q.o.MaxAttempts && isTransient(err) && ctx.Err() == nil {
+		q.l.Warn("command-retry", "command", c.Name, "target", c.Target, "attempt", c.attempts, "error", err)
+		time.AfterFunc(time.Duration(c.attempts)*q.o.RetryDelay, func() { q.Push(c) })
+		return
+	}
+	c.done(err)
+}
+
+// Len returns the number of pending commands of the priority.
+func (q *commandQueue) Len(p priority) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending[p])
+}
+
+// isTransient reports whether the command failed for a reason which might go away when trying again, e.g. a lost
+// connection.
+func isTransient(err error) bool {
+	var status *rconv2.UnexpectedStatus
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, rconv2.ErrInvalidCredentials) && !errors.As(err, &status)
+}
+
+// rateLimit allows Burst commands at once, and one more command every Interval. An Interval of zero disables the
+// limit.
+type rateLimit struct {
+	Interval time.Duration
+	Burst    int
+}
+
+type limiter struct {
+	rateLimit
+	// tat is the theoretical arrival time of the next command.
+	tat time.Time
+}
+
+// take returns zero and counts the command when it is allowed to run now, otherwise the time until it is allowed.
+func (l *limiter) take(now time.Time) time.Duration {
+	if l.Interval <= 0 {
+		return 0
+	}
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	allowAt := tat.Add(-time.Duration(max(l.Burst, 1)-1) * l.Interval)
+	if now.Before(allowAt) {
+		return allowAt.Sub(now)
+	}
+	l.tat = tat.Add(l.Interval)
+	return 0
+}
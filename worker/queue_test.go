@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("commandQueue", func() {
+	var q *commandQueue
+	var o queueOptions
+	now := time.Now()
+
+	BeforeEach(func() {
+		o = queueOptions{Workers: 2, MaxPending: 2, MaxAttempts: 3, RetryDelay: time.Millisecond}
+	})
+
+	JustBeforeEach(func() {
+		q = newCommandQueue(slog.New(slog.NewTextHandler(io.Discard, nil)), o)
+	})
+
+	cmd := func(p priority, name string) *command {
+		return &command{Priority: p, Name: name, Run: func(ctx context.Context) error { return nil }}
+	}
+
+	It("takes commands by priority", func() {
+		q.Push(cmd(priorityBroadcast, "broadcast"))
+		q.Push(cmd(priorityWarn, "warn"))
+		q.Push(cmd(priorityPoll, "poll"))
+		q.Push(cmd(priorityPunish, "punish"))
+
+		var names []string
+		for c, _, _ := q.next(now, false); c != nil; c, _, _ = q.next(now, false) {
+			names = append(names, c.Name)
+		}
+		Expect(names).To(Equal([]string{"poll", "punish", "warn", "broadcast"}))
+	})
+
+	It("takes only polls in the reserved worker", func() {
+		q.Push(cmd(priorityWarn, "warn"))
+
+		c, _, _ := q.next(now, true)
+		Expect(c).To(BeNil())
+		q.Push(cmd(priorityPoll, "poll"))
+		c, _, _ = q.next(now, true)
+		Expect(c.Name).To(Equal("poll"))
+	})
+
+	It("drops stale and expired commands", func() {
+		var dropped []error
+		stale := cmd(priorityWarn, "stale")
+		stale.Stale = func() bool { return true }
+		stale.Done = func(err error) { dropped = append(dropped, err) }
+		expired := cmd(priorityPunish, "expired")
+		expired.Expires = now.Add(-time.Second)
+		expired.Done = func(err error) { dropped = append(dropped, err) }
+		q.Push(stale)
+		q.Push(expired)
+
+		c, _, _ := q.next(now, false)
+		Expect(c).To(BeNil())
+		Expect(dropped).To(Equal([]error{errStaleCommand, errStaleCommand}))
+	})
+
+	It("drops commands when too many are pending", func() {
+		var err error
+		for i := 0; i < 3; i++ {
+			c := cmd(priorityWarn, "warn")
+			c.Done = func(e error) { err = e }
+			q.Push(c)
+		}
+		Expect(q.Len(priorityWarn)).To(Equal(2))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with rate limits", func() {
+		BeforeEach(func() {
+			o.Limits[priorityWarn] = rateLimit{Interval: time.Second, Burst: 2}
+		})
+
+		It("delays commands exceeding the limit", func() {
+			q.Push(cmd(priorityWarn, "1"))
+			q.Push(cmd(priorityWarn, "2"))
+			q.Push(cmd(priorityPunish, "3"))
+			c, _, _ := q.next(now, false)
+			Expect(c.Name).To(Equal("3"))
+			c, _, _ = q.next(now, false)
+			Expect(c.Name).To(Equal("1"))
+			c, _, _ = q.next(now, false)
+			Expect(c.Name).To(Equal("2"))
+
+			q.Push(cmd(priorityWarn, "4"))
+			c, wait, _ := q.next(now, false)
+			Expect(c).To(BeNil())
+			Expect(wait).To(Equal(time.Second))
+			c, _, _ = q.next(now.Add(time.Second), false)
+			Expect(c.Name).To(Equal("4"))
+		})
+	})
+
+	Context("running", func() {
+		var ctx context.Context
+		var cancel context.CancelFunc
+
+		JustBeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			q.Run(ctx)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("retries transient failures", func() {
+			var attempts atomic.Int32
+			done := make(chan error, 1)
+			q.Push(&command{Priority: priorityWarn, Name: "warn", Run: func(ctx context.Context) error {
+				if attempts.Add(1) < 3 {
+					return io.EOF
+				}
+				return nil
+			}, Done: func(err error) { done <- err }})
+
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(attempts.Load()).To(BeEquivalentTo(3))
+		})
+
+		It("does not retry permanent failures or polls", func() {
+			var attempts atomic.Int32
+			done := make(chan error, 2)
+			q.Push(&command{Priority: priorityWarn, Name: "warn", Run: func(ctx context.Context) error {
+				attempts.Add(1)
+				return rconv2.NewUnexpectedStatus(400, "bad request")
+			}, Done: func(err error) { done <- err }})
+			q.Push(&command{Priority: priorityPoll, Name: "poll", Run: func(ctx context.Context) error {
+				attempts.Add(1)
+				return io.EOF
+			}, Done: func(err error) { done <- err }})
+
+			Eventually(done).Should(Receive(HaveOccurred()))
+			Eventually(done).Should(Receive(HaveOccurred()))
+			Consistently(attempts.Load, 20*time.Millisecond).Should(BeEquivalentTo(2))
+		})
+
+		Context("with a single worker", func() {
+			BeforeEach(func() {
+				o.Workers = 1
+			})
+
+			It("still reserves a worker for polls", func() {
+				release := make(chan struct{})
+				defer close(release)
+				q.Push(&command{Priority: priorityWarn, Name: "warn", Run: func(ctx context.Context) error {
+					<-release
+					return nil
+				}})
+				Eventually(func() int { return q.Len(priorityWarn) }).Should(BeZero())
+
+				done := make(chan error, 1)
+				q.Push(&command{Priority: priorityPoll, Name: "poll", Run: func(ctx context.Context) error {
+					return nil
+				}, Done: func(err error) { done <- err }})
+				Eventually(done).Should(Receive(BeNil()))
+			})
+		})
+
+		It("runs all pushed commands concurrently with the workers", func() {
+			var wg gosync.WaitGroup
+			var ran atomic.Int32
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				q.Push(&command{Priority: priorityPoll, Name: "poll", Run: func(ctx context.Context) error {
+					ran.Add(1)
+					return errors.New("failed")
+				}, Done: func(error) { wg.Done() }})
+			}
+			wg.Wait()
+			Expect(ran.Load()).To(BeEquivalentTo(10))
+		})
+	})
+})
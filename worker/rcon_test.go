@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+)
+
+// fakeServer is a game server the worker talks to in tests instead of a connection pool. It returns the configured
+// players and session and records the messages and punishments sent to it.
+type fakeServer struct {
+	mu        sync.Mutex
+	players   []api.GetPlayerResponse
+	session   *api.GetSessionResponse
+	punishErr error
+	// latency is the time each command takes.
+	latency time.Duration
+	// connections limits the number of commands run concurrently like a connection pool, if not nil.
+	connections chan struct{}
+	// polls is the number of times the players were fetched.
+	polls int
+	// adminLog is the admin log returned by every poll of it, logPolls the number of polls.
+	adminLog []api.AdminLogEntry
+	logPolls int
+	messages []sentMessage
+	punished []string
+}
+
+type sentMessage struct {
+	PlayerId string
+	Message  string
+}
+
+func newFakeServer(session *api.GetSessionResponse) *fakeServer {
+	return &fakeServer{session: session}
+}
+
+// connect runs f with the fake server as connection, see Worker.connect. Like the pool, it waits for a connection
+// to be released when all of them are in use.
+func (s *fakeServer) connect(ctx context.Context, f func(c connection) error) error {
+	s.mu.Lock()
+	latency, connections := s.latency, s.connections
+	s.mu.Unlock()
+	if connections != nil {
+		select {
+		case connections <- struct{}{}:
+			defer func() { <-connections }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	time.Sleep(latency)
+	return f(s)
+}
+
+// limitConnections lets only n commands run at the same time.
+func (s *fakeServer) limitConnections(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections = make(chan struct{}, n)
+}
+
+func (s *fakeServer) setLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+func (s *fakeServer) setPlayers(players ...api.GetPlayerResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.players = players
+}
+
+func (s *fakeServer) setSession(si *api.GetSessionResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = si
+}
+
+// log appends an entry with the given message to the admin log.
+func (s *fakeServer) log(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adminLog = append(s.adminLog, api.AdminLogEntry{
+		Timestamp: time.Now().Format("2006.01.02-15:04:05") + fmt.Sprintf(":%d", len(s.adminLog)),
+		Message:   message,
+	})
+}
+
+func (s *fakeServer) setPunishErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.punishErr = err
+}
+
+func (s *fakeServer) Players(context.Context) (*api.GetPlayersResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls++
+	return &api.GetPlayersResponse{Players: append([]api.GetPlayerResponse(nil), s.players...)}, nil
+}
+
+func (s *fakeServer) SessionInfo(context.Context) (*api.GetSessionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	si := *s.session
+	return &si, nil
+}
+
+func (s *fakeServer) AdminLog(context.Context, int32, string) (*api.GetAdminLogResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logPolls++
+	return &api.GetAdminLogResponse{Entries: append([]api.AdminLogEntry(nil), s.adminLog...)}, nil
+}
+
+func (s *fakeServer) MessagePlayer(_ context.Context, playerId, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, sentMessage{PlayerId: playerId, Message: message})
+	return nil
+}
+
+func (s *fakeServer) PunishPlayer(_ context.Context, playerId, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.punishErr != nil {
+		return s.punishErr
+	}
+	s.punished = append(s.punished, playerId)
+	return nil
+}
+
+func (s *fakeServer) Messages() []sentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sentMessage(nil), s.messages...)
+}
+
+func (s *fakeServer) Punished() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.punished...)
+}
+
+func (s *fakeServer) Polls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls
+}
+
+func (s *fakeServer) LogPolls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logPolls
+}
+
+// runWorker connects the worker to the fake server and starts its command queue, session polls and evaluation loop,
+// which stop with the context.
+func runWorker(ctx context.Context, w *Worker, s *fakeServer) {
+	w.connect = s.connect
+	w.queue.Run(ctx)
+	go w.pollSession(ctx)
+	go w.evaluationLoop(ctx)
+}
+
+// inLoop runs f in the evaluation loop of a running worker and waits until it returned, so that tests can inspect the
+// state of players.
+func inLoop(w *Worker, f func()) {
+	done := make(chan struct{})
+	w.do(func() {
+		defer close(done)
+		f()
+	})
+	<-done
+}
@@ -0,0 +1,95 @@
+package worker
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// scheduler runs exactly one function per key at a deadline. Scheduling a key again replaces the previous deadline
+// of that key, cancelling a key makes sure the function of the key does not run anymore, unless it already started.
+type scheduler struct {
+	mu      gosync.Mutex
+	gen     uint64
+	entries map[string]scheduledEntry
+	stopped bool
+	running gosync.WaitGroup
+}
+
+type scheduledEntry struct {
+	timer *time.Timer
+	gen   uint64
+	at    time.Time
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		entries: map[string]scheduledEntry{},
+	}
+}
+
+// Schedule runs f at the given time, or immediately if the time already passed.
+func (s *scheduler) Schedule(key string, at time.Time, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if e, ok := s.entries[key]; ok {
+		e.timer.Stop()
+	}
+	s.gen++
+	gen := s.gen
+	s.entries[key] = scheduledEntry{
+		gen: gen,
+		at:  at,
+		timer: time.AfterFunc(time.Until(at), func() {
+			if !s.take(key, gen) {
+				return
+			}
+			defer s.running.Done()
+			f()
+		}),
+	}
+}
+
+// take removes the entry of the key when it is still the one with the given generation, and marks it as running.
+func (s *scheduler) take(key string, gen uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; !ok || e.gen != gen || s.stopped {
+		return false
+	}
+	delete(s.entries, key)
+	s.running.Add(1)
+	return true
+}
+
+func (s *scheduler) Cancel(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.timer.Stop()
+		delete(s.entries, key)
+	}
+}
+
+// Deadline returns the time the function of the key is scheduled at.
+func (s *scheduler) Deadline(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e.at, ok
+}
+
+// Stop cancels all scheduled functions and waits for the ones already running to finish. Functions cannot be
+// scheduled after the scheduler stopped.
+func (s *scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	for key, e := range s.entries {
+		e.timer.Stop()
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+	s.running.Wait()
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("scheduler", func() {
+	var s *scheduler
+
+	BeforeEach(func() {
+		s = newScheduler()
+	})
+
+	AfterEach(func() {
+		s.Stop()
+	})
+
+	It("runs the function at the deadline", func() {
+		var ran atomic.Int32
+		s.Schedule("a", time.Now().Add(20*time.Millisecond), func() { ran.Add(1) })
+
+		Expect(ran.Load()).To(BeZero())
+		Eventually(ran.Load).Should(BeEquivalentTo(1))
+		Consistently(ran.Load, 50*time.Millisecond).Should(BeEquivalentTo(1))
+	})
+
+	It("runs functions of passed deadlines immediately", func() {
+		var ran atomic.Int32
+		s.Schedule("a", time.Now().Add(-time.Second), func() { ran.Add(1) })
+
+		Eventually(ran.Load).Should(BeEquivalentTo(1))
+	})
+
+	It("does not run cancelled functions", func() {
+		var ran atomic.Int32
+		s.Schedule("a", time.Now().Add(20*time.Millisecond), func() { ran.Add(1) })
+		s.Cancel("a")
+
+		_, ok := s.Deadline("a")
+		Expect(ok).To(BeFalse())
+		Consistently(ran.Load, 50*time.Millisecond).Should(BeZero())
+	})
+
+	It("replaces the deadline of the same key", func() {
+		var first, second atomic.Int32
+		s.Schedule("a", time.Now().Add(10*time.Millisecond), func() { first.Add(1) })
+		s.Schedule("a", time.Now().Add(30*time.Millisecond), func() { second.Add(1) })
+
+		Eventually(second.Load).Should(BeEquivalentTo(1))
+		Expect(first.Load()).To(BeZero())
+	})
+
+	It("runs exactly once when scheduled concurrently", func() {
+		var ran atomic.Int32
+		var wg gosync.WaitGroup
+		at := time.Now().Add(20 * time.Millisecond)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Schedule("a", at, func() { ran.Add(1) })
+			}()
+		}
+		wg.Wait()
+
+		Eventually(ran.Load).Should(BeEquivalentTo(1))
+		Consistently(ran.Load, 50*time.Millisecond).Should(BeEquivalentTo(1))
+	})
+
+	It("does not run functions after it stopped", func() {
+		var ran atomic.Int32
+		s.Schedule("a", time.Now().Add(10*time.Millisecond), func() { ran.Add(1) })
+		s.Stop()
+		s.Schedule("b", time.Now(), func() { ran.Add(1) })
+
+		Consistently(ran.Load, 50*time.Millisecond).Should(BeZero())
+	})
+})
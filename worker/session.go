@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+)
+
+// session is an immutable snapshot of the session of the server together with the fences applicable to it.
+type session struct {
+	info *api.GetSessionResponse
+	// geometry is the geometry of the map, or nil if the map is not known.
+	geometry *data.Geometry
+	axis     teamFences
+	allies   teamFences
+}
+
+type teamFences struct {
+	allow []data.Fence
+	deny  []data.Fence
+	// roles are the fences compiled for each role of a player, see compile.
+	roles [roleCount]compiledFences
+}
+
+const roleCount = api.PlayerRoleArmyCommander + 1
+
+// compiledFences are the allowed and denied cells for players of a specific role, which allow to check the position
+// of a player in constant time, regardless of the number of fences.
+type compiledFences struct {
+	allow    data.CellSet
+	deny     data.CellSet
+	hasAllow bool
+	hasDeny  bool
+}
+
+func compileFences(allow, deny []data.Fence) compiledFences {
+	return compiledFences{
+		allow:    data.FenceCells(allow),
+		deny:     data.FenceCells(deny),
+		hasAllow: len(allow) != 0,
+		hasDeny:  len(deny) != 0,
+	}
+}
+
+// applies reports whether there are any fences at all.
+func (c *compiledFences) applies() bool {
+	return c.hasAllow || c.hasDeny
+}
+
+// Covers implements data.Area for the allowed area.
+func (c *compiledFences) Covers(g api.Grid) uint16 {
+	allow := data.FullNumpad
+	if c.hasAllow {
+		allow = c.allow.Covers(g)
+	}
+	return allow &^ c.deny.Covers(g)
+}
+
+// includes reports whether the cell is inside the allowed area.
+func (c *compiledFences) includes(cell data.Cell) bool {
+	m := uint16(data.FullNumpad)
+	if cell.SubNumpad != 0 {
+		m = 1 << (cell.SubNumpad - 1)
+	}
+	return c.Covers(cell.Grid)&m == m
+}
+
+// nearBoundary reports whether the cell is inside the allowed area, but in or next to a numpad which is not
+// entirely inside of it.
+func (c *compiledFences) nearBoundary(cell data.Cell) bool {
+	if !c.includes(cell) {
+		return false
+	}
+	if c.Covers(cell.Grid) != data.FullNumpad {
+		return true
+	}
+	for _, a := range data.Adjacent(cell.Grid) {
+		if c.Covers(a) != data.FullNumpad {
+			return true
+		}
+	}
+	return false
+}
+
+// outside is the area outside of the allowed area of the compiled fences.
+type outside struct {
+	*compiledFences
+}
+
+func (o outside) Covers(g api.Grid) uint16 {
+	return data.FullNumpad &^ o.compiledFences.Covers(g)
+}
+
+// nearestAllowed returns the closest point of the allowed area to the position, its distance in metres and the
+// compass direction to go to it.
+func (s *session) nearestAllowed(c *compiledFences, p api.WorldPosition) (distance float64, direction string, ok bool) {
+	if s.geometry == nil {
+		return 0, "", false
+	}
+	nearest, d, ok := s.geometry.Nearest(p, c)
+	if !ok {
+		return 0, "", false
+	}
+	return d / 100, data.Direction(p, nearest), true
+}
+
+// cell returns the cell of the position. It returns an empty cell, which is outside of any fence, if the map is not
+// known.
+func (s *session) cell(p api.WorldPosition) data.Cell {
+	if s.geometry == nil {
+		return data.Cell{}
+	}
+	c, _ := s.geometry.Cell(p)
+	return c
+}
+
+func (t *teamFences) compile() {
+	for r := range t.roles {
+		t.roles[r] = compileFences(roleFences(t.allow, api.PlayerRole(r)), roleFences(t.deny, api.PlayerRole(r)))
+	}
+}
+
+// newSession creates a snapshot of the session with the applicable fences of the fence set. The compiled fences of
+// the previous snapshot are reused, if the applicable fences did not change.
+func newSession(si *api.GetSessionResponse, set data.FenceSet, prev *session) *session {
+	s := &session{
+		info: si,
+		axis: teamFences{
+			allow: applicableFences(si, set.AxisFence),
+			deny:  applicableFences(si, set.AxisDenyFence),
+		},
+		allies: teamFences{
+			allow: applicableFences(si, set.AlliesFence),
+			deny:  applicableFences(si, set.AlliesDenyFence),
+		},
+	}
+	if g, ok := data.NewGeometry(si); ok {
+		s.geometry = &g
+	}
+	if prev != nil && reflect.DeepEqual(prev.fences(), s.fences()) {
+		s.axis.roles, s.allies.roles = prev.axis.roles, prev.allies.roles
+	} else {
+		s.axis.compile()
+		s.allies.compile()
+	}
+	return s
+}
+
+func (s *session) fences() [][]data.Fence {
+	return [][]data.Fence{s.axis.allow, s.axis.deny, s.allies.allow, s.allies.deny}
+}
+
+func (s *session) hasFences() bool {
+	if s == nil {
+		return false
+	}
+	return len(s.axis.allow) != 0 || len(s.axis.deny) != 0 || len(s.allies.allow) != 0 || len(s.allies.deny) != 0
+}
+
+func (s *session) team(t api.PlayerTeam) *teamFences {
+	if slices.Contains(alliedTeams, t) {
+		return &s.allies
+	} else if slices.Contains(axisTeams, t) {
+		return &s.axis
+	}
+	return &teamFences{}
+}
+
+// playerFences returns the allowed and denied fences applicable to the team and role of the player.
+func (s *session) playerFences(p api.GetPlayerResponse) (fences []data.Fence, denyFences []data.Fence) {
+	t := s.team(p.Team)
+	return roleFences(t.allow, p.Role), roleFences(t.deny, p.Role)
+}
+
+// compiledFences returns the compiled fences applicable to the team and role of the player.
+func (s *session) compiledFences(p api.GetPlayerResponse) *compiledFences {
+	t := s.team(p.Team)
+	if p.Role >= 0 && p.Role < roleCount {
+		return &t.roles[p.Role]
+	}
+	c := compileFences(s.playerFences(p))
+	return &c
+}
+
+func roleFences(f []data.Fence, r api.PlayerRole) (v []data.Fence) {
+	for _, fence := range f {
+		if fence.AppliesTo(r) {
+			v = append(v, fence)
+		}
+	}
+	return
+}
+
+func applicableFences(si *api.GetSessionResponse, f []data.Fence) (v []data.Fence) {
+	for _, fence := range f {
+		if fence.Matches(si) {
+			v = append(v, fence)
+		}
+	}
+	return
+}
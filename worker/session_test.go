@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"os"
+	"testing"
+
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+// linearIncludes checks the grid against every fence, as players were checked before fences were compiled.
+func linearIncludes(s *session, p api.GetPlayerResponse, g api.Grid) bool {
+	fences, denyFences := s.playerFences(p)
+	inside := len(fences) == 0
+	for _, f := range fences {
+		if f.Includes(g) {
+			inside = true
+			break
+		}
+	}
+	for _, f := range denyFences {
+		if f.Includes(g) {
+			return false
+		}
+	}
+	return inside
+}
+
+func loadServer(path string) data.Server {
+	b, err := os.ReadFile(path)
+	Expect(err).ToNot(HaveOccurred())
+	var c data.Config
+	Expect(yaml.Unmarshal(b, &c)).ToNot(HaveOccurred())
+	Expect(c.Servers).ToNot(BeEmpty())
+	return c.Servers[0]
+}
+
+func allGrids() (grids []api.Grid) {
+	for _, x := range data.Columns {
+		for y := 1; y <= data.Rows; y++ {
+			for n := 1; n <= data.Numpads; n++ {
+				grids = append(grids, api.Grid{X: x, Y: y, Numpad: n})
+			}
+		}
+	}
+	return
+}
+
+var foy = &api.GetSessionResponse{MapName: "FOY", GameMode: "Warfare", PlayerCount: 10}
+
+var _ = Describe("compiled fences", func() {
+	for _, path := range []string{"../seeding.lastcap.yml", "../seeding.midcap.yml"} {
+		path := path
+		It("match the fences of "+path, func() {
+			c := loadServer(path)
+			set, _ := c.FenceSet("")
+			for _, si := range []*api.GetSessionResponse{carentan, foy} {
+				s := newSession(si, set, nil)
+				Expect(s.hasFences()).To(BeTrue())
+				for _, team := range []api.PlayerTeam{api.PlayerTeamGer, api.PlayerTeamUs} {
+					for r := api.PlayerRole(0); r < roleCount; r++ {
+						p := api.GetPlayerResponse{Team: team, Role: r}
+						cf := s.compiledFences(p)
+						for _, g := range allGrids() {
+							Expect(cf.includes(data.Cell{Grid: g})).To(Equal(linearIncludes(s, p, g)), "%s team %d role %d grid %s", si.MapName, team, r, g)
+						}
+					}
+				}
+			}
+		})
+	}
+
+	It("respect roles and deny fences", func() {
+		s := newSession(carentan, data.FenceSet{
+			AlliesFence:     []data.Fence{{X: Pointer("E")}, {X: Pointer("F"), Roles: []string{"Tank Commander"}}},
+			AlliesDenyFence: []data.Fence{{X: Pointer("E"), Y: Pointer(5)}},
+		}, nil)
+		rifleman := api.GetPlayerResponse{Team: api.PlayerTeamUs, Role: api.PlayerRoleRifleman}
+		tanker := api.GetPlayerResponse{Team: api.PlayerTeamUs, Role: api.PlayerRoleTankCommander}
+		axis := api.GetPlayerResponse{Team: api.PlayerTeamGer, Role: api.PlayerRoleRifleman}
+
+		Expect(s.compiledFences(rifleman).includes(data.Cell{Grid: api.Grid{X: "E", Y: 4, Numpad: 1}})).To(BeTrue())
+		Expect(s.compiledFences(rifleman).includes(data.Cell{Grid: api.Grid{X: "E", Y: 5, Numpad: 1}})).To(BeFalse())
+		Expect(s.compiledFences(rifleman).includes(data.Cell{Grid: api.Grid{X: "F", Y: 4, Numpad: 1}})).To(BeFalse())
+		Expect(s.compiledFences(tanker).includes(data.Cell{Grid: api.Grid{X: "F", Y: 4, Numpad: 1}})).To(BeTrue())
+		Expect(s.compiledFences(axis).applies()).To(BeFalse())
+	})
+
+	It("deny sub numpads of allowed numpads", func() {
+		s := newSession(carentan, data.FenceSet{
+			AlliesFence:     []data.Fence{{X: Pointer("E")}},
+			AlliesDenyFence: []data.Fence{{X: Pointer("E"), Y: Pointer(5), Numpads: []int{7}, SubNumpads: []int{3}}},
+		}, nil)
+		cf := s.compiledFences(api.GetPlayerResponse{Team: api.PlayerTeamUs, Role: api.PlayerRoleRifleman})
+		numpad := api.Grid{X: "E", Y: 5, Numpad: 7}
+
+		Expect(cf.includes(data.Cell{Grid: numpad, SubNumpad: 2})).To(BeTrue())
+		Expect(cf.includes(data.Cell{Grid: numpad, SubNumpad: 3})).To(BeFalse())
+		Expect(cf.nearBoundary(data.Cell{Grid: numpad, SubNumpad: 2})).To(BeTrue())
+		Expect(cf.nearBoundary(data.Cell{Grid: api.Grid{X: "E", Y: 5, Numpad: 1}, SubNumpad: 5})).To(BeTrue())
+		Expect(cf.nearBoundary(data.Cell{Grid: api.Grid{X: "E", Y: 5, Numpad: 2}, SubNumpad: 5})).To(BeFalse())
+	})
+
+	It("are reused when the fences did not change", func() {
+		set := data.FenceSet{AlliesFence: []data.Fence{{X: Pointer("E")}}}
+		prev := newSession(carentan, set, nil)
+		prev.allies.roles[0].hasDeny = true // marker to detect reuse
+
+		Expect(newSession(carentan, set, prev).allies.roles[0].hasDeny).To(BeTrue())
+		changed := data.FenceSet{AlliesFence: []data.Fence{{X: Pointer("F")}}}
+		Expect(newSession(carentan, changed, prev).allies.roles[0].hasDeny).To(BeFalse())
+	})
+})
+
+func benchmarkFences(b *testing.B, includes func(s *session, p api.GetPlayerResponse, g api.Grid) bool) {
+	bf, err := os.ReadFile("../seeding.lastcap.yml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var c data.Config
+	if err := yaml.Unmarshal(bf, &c); err != nil {
+		b.Fatal(err)
+	}
+	set, _ := c.Servers[0].FenceSet("")
+	s := newSession(foy, set, nil)
+	p := api.GetPlayerResponse{Team: api.PlayerTeamUs, Role: api.PlayerRoleRifleman}
+	grids := allGrids()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		includes(s, p, grids[i%len(grids)])
+	}
+}
+
+func BenchmarkLinearFences(b *testing.B) {
+	benchmarkFences(b, linearIncludes)
+}
+
+func BenchmarkCompiledFences(b *testing.B) {
+	benchmarkFences(b, func(s *session, p api.GetPlayerResponse, g api.Grid) bool {
+		return s.compiledFences(p).includes(data.Cell{Grid: g})
+	})
+}
@@ -1,309 +1,386 @@
 package worker
 
 import (
-        "context"
-        "log/slog"
-        "slices"
-        "time"
+	"context"
+	"log/slog"
+	"reflect"
+	gosync "sync"
+	"time"
 
-        "github.com/floriansw/go-hll-rcon/rconv2"
-        "github.com/floriansw/go-hll-rcon/rconv2/api"
-        "github.com/floriansw/hll-geofences/data"
-        "github.com/floriansw/hll-geofences/sync"
+	"github.com/floriansw/go-hll-rcon/rconv2"
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	"github.com/floriansw/hll-geofences/sync"
 )
 
 type Worker struct {
-        pool               *rconv2.ConnectionPool
-        l                  *slog.Logger
-        c                  data.Server
-        axisFences         []data.Fence
-        alliesFences       []data.Fence
-        punishAfterSeconds time.Duration
-        sessionTicker      *time.Ticker
-        playerTicker       *time.Ticker
-        punishTicker       *time.Ticker
-        inactivityTicker   *time.Ticker
-        lastMapChange      time.Time
-        current            *api.GetSessionResponse
-        outsidePlayers     sync.Map[string, outsidePlayer]
-        trackedPlayers     sync.Map[string, struct{}] // Added: Track players who have entered an allowed fence
-        restartCh          chan struct{}
-}
-
-type outsidePlayer struct {
-        Name         string
-        LastGrid     api.Grid
-        FirstOutside time.Time
+	// connect runs a function with a connection to the server, see withConnection.
+	connect          func(ctx context.Context, f func(c connection) error) error
+	l                *slog.Logger
+	c                data.Server
+	sessionTicker    *time.Ticker
+	playerTicker     *time.Ticker
+	inactivityTicker *time.Ticker
+	whitelist        *data.WhitelistFile
+	lastMapChange    sync.Value[time.Time]
+	session          sync.Value[*session]
+	// refresh requests pollSession to refresh the session snapshot right away, see refreshSession.
+	refresh chan chan error
+	// players is the enforcement state of each player. It is owned by the evaluation loop and must only be accessed
+	// from within it, see do and post.
+	players      map[string]*playerState
+	nearBoundary int // number of players next to the boundary of the fences, owned by the evaluation loop
+	// unknownMap is the map and game mode players are not evaluated on, as their grid is not known, see
+	// skipUnknownMap. It is owned by the evaluation loop.
+	unknownMap      string
+	control         chan func()
+	done            chan struct{}
+	postMu          gosync.Mutex
+	posted          []func() // functions passed to post, which the evaluation loop did not run yet
+	wake            chan struct{}
+	queue           *commandQueue // all commands sent to the server, see rcon and enqueue
+	outside         sync.Value[int]
+	restartCh       chan struct{}
+	fenceSet        sync.Value[string]
+	disabled        sync.Value[bool]
+	pausedUntil     sync.Value[time.Time]
+	exemptions      sync.Map[string, time.Time]
+	playerIds       sync.Map[string, string] // player IDs by name, as some admin log events only contain the name
+	matchStartedAt  sync.Value[time.Time]
+	fencesChangedAt sync.Value[time.Time]
+	punishments     *scheduler // deadlines of punishments by player ID
+	diagnostics     diagnostics
 }
 
 var alliedTeams = []api.PlayerTeam{
-        api.PlayerTeamB8a,
-        api.PlayerTeamDak,
-        api.PlayerTeamGb,
-        api.PlayerTeamRus,
-        api.PlayerTeamUs,
+	api.PlayerTeamB8a,
+	api.PlayerTeamDak,
+	api.PlayerTeamGb,
+	api.PlayerTeamRus,
+	api.PlayerTeamUs,
 }
 
 var axisTeams = []api.PlayerTeam{
-        api.PlayerTeamGer,
+	api.PlayerTeamGer,
 }
 
 func (w *Worker) Host() string {
-        return w.c.Host
+	return w.c.Host
 }
 
 func NewWorker(l *slog.Logger, pool *rconv2.ConnectionPool, c data.Server) *Worker {
-        punishAfterSeconds := 10
-        if c.PunishAfterSeconds != nil {
-                punishAfterSeconds = *c.PunishAfterSeconds
-        }
-        return &Worker{
-                l:                  l,
-                pool:               pool,
-                punishAfterSeconds: time.Duration(punishAfterSeconds) * time.Second,
-                c:                  c,
-                sessionTicker:      time.NewTicker(1 * time.Second),
-                playerTicker:       time.NewTicker(500 * time.Millisecond),
-                punishTicker:       time.NewTicker(time.Second),
-                inactivityTicker:   time.NewTicker(2 * time.Hour),
-                lastMapChange:      time.Now(),
-                outsidePlayers:     sync.Map[string, outsidePlayer]{},
-                trackedPlayers:     sync.Map[string, struct{}]{}, // Initialize tracked players map
-                restartCh:          make(chan struct{}),
-        }
+	o := defaultQueueOptions
+	// Do not run more commands concurrently than connections are available. The worker reserved for polls is kept
+	// with a single connection, the pool then lets it wait for the connection to be released.
+	if n := c.MaxOpenConnections(); n != nil && *n < o.Workers {
+		o.Workers = max(*n, 2)
+	}
+	w := &Worker{
+		l:                l,
+		connect:          poolConnect(pool),
+		c:                c,
+		sessionTicker:    time.NewTicker(c.SessionInterval()),
+		playerTicker:     time.NewTicker(c.PlayersInterval()),
+		inactivityTicker: time.NewTicker(c.InactivityRestart()),
+		refresh:          make(chan chan error),
+		players:          map[string]*playerState{},
+		control:          make(chan func()),
+		wake:             make(chan struct{}, 1),
+		done:             make(chan struct{}),
+		queue:            newCommandQueue(l, o),
+		punishments:      newScheduler(),
+		restartCh:        make(chan struct{}),
+	}
+	w.lastMapChange.Store(time.Now())
+	return w
 }
 
 func (w *Worker) RestartSignal() <-chan struct{} {
-        return w.restartCh
+	return w.restartCh
 }
 
 func (w *Worker) Run(ctx context.Context) {
-        if err := w.populateSession(ctx); err != nil {
-                w.l.Error("fetch-session", "error", err)
-                return
-        }
+	w.queue.Run(ctx)
+	if err := w.populateSession(ctx); err != nil {
+		w.l.Error("fetch-session", "error", err)
+		return
+	}
 
-        go w.pollSession(ctx)
-        go w.pollPlayers(ctx)
-        go w.punishPlayers(ctx)
-        go w.checkInactivity(ctx)
-}
+	if w.c.Whitelist != nil && w.c.Whitelist.File != nil {
+		f, err := data.NewWhitelistFile(*w.c.Whitelist.File)
+		if err != nil {
+			w.l.Error("load-whitelist-file", "path", *w.c.Whitelist.File, "error", err)
+		} else {
+			w.whitelist = f
+			go w.reloadWhitelist(ctx)
+		}
+	}
 
-func (w *Worker) populateSession(ctx context.Context) error {
-        return w.pool.WithConnection(ctx, func(c *rconv2.Connection) error {
-                si, err := c.SessionInfo(ctx)
-                if err != nil {
-                        return err
-                }
-                if w.current != nil && w.current.MapName != si.MapName {
-                        w.l.Info("map-changed", "old_map", w.current.MapName, "new_map", si.MapName)
-                        w.lastMapChange = time.Now()
-                        select {
-                        case w.restartCh <- struct{}{}:
-                                w.l.Info("signaled-restart-on-map-change")
-                        default:
-                                w.l.Warn("restart-channel-full")
-                        }
-                }
-                w.current = si
-                w.axisFences = w.applicableFences(w.c.AxisFence)
-                w.alliesFences = w.applicableFences(w.c.AlliesFence)
-                return nil
-        })
-}
+	w.l.Info("outside-spawn-policy", "policy", w.c.OutsideSpawnPolicy(), "seconds", w.c.OutsideSpawnTime().Seconds())
 
-func (w *Worker) checkInactivity(ctx context.Context) {
-        for {
-                select {
-                case <-ctx.Done():
-                        w.inactivityTicker.Stop()
-                        return
-                case <-w.inactivityTicker.C:
-                        if time.Since(w.lastMapChange) >= 2*time.Hour {
-                                err := w.pool.WithConnection(ctx, func(c *rconv2.Connection) error {
-                                        players, err := c.Players(ctx)
-                                        if err != nil {
-                                                return err
-                                        }
-                                        if len(players.Players) == 0 {
-                                                w.l.Info("no-players-inactivity-restart")
-                                                select {
-                                                case w.restartCh <- struct{}{}:
-                                                        w.l.Info("signaled-restart-on-inactivity")
-                                                        w.lastMapChange = time.Now()
-                                                default:
-                                                        w.l.Warn("restart-channel-full")
-                                                }
-                                        }
-                                        return nil
-                                })
-                                if err != nil {
-                                        w.l.Error("check-inactivity", "error", err)
-                                }
-                        }
-                }
-        }
+	go w.pollSession(ctx)
+	go w.evaluationLoop(ctx)
+	go w.checkInactivity(ctx)
+	go w.pollLog(ctx)
 }
 
-func (w *Worker) punishPlayers(ctx context.Context) {
-        for {
-                select {
-                case <-ctx.Done():
-                        w.punishTicker.Stop()
-                        return
-                case <-w.punishTicker.C:
-                        w.outsidePlayers.Range(func(id string, o outsidePlayer) bool {
-                                if time.Since(o.FirstOutside) > w.punishAfterSeconds && time.Since(o.FirstOutside) < w.punishAfterSeconds+5*time.Second {
-                                        go w.punishPlayer(ctx, id, o)
-                                }
-                                return true
-                        })
-                }
-        }
+func (w *Worker) reloadWhitelist(ctx context.Context) {
+	t := time.NewTicker(10 * time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+			changed, err := w.whitelist.Reload()
+			if err != nil {
+				w.l.Error("reload-whitelist-file", "path", w.whitelist.Path(), "error", err)
+			} else if changed {
+				w.l.Info("reloaded-whitelist-file", "path", w.whitelist.Path(), "entries", len(w.whitelist.Entries()))
+			}
+		}
+	}
 }
 
-func (w *Worker) punishPlayer(ctx context.Context, id string, o outsidePlayer) {
-        message := w.c.PunishMessage()
-        w.l.Debug("punish-message-final", "message", message)
+// populateSession fetches the current session of the server and replaces the session snapshot used to evaluate
+// players. Once the worker runs, it must only be called from pollSession, so that an older snapshot never replaces a
+// newer one; see refreshSession.
+func (w *Worker) populateSession(ctx context.Context) error {
+	var si *api.GetSessionResponse
+	err := w.rcon(ctx, priorityPoll, "session-info", func(c connection) (err error) {
+		si, err = c.SessionInfo(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.updateSession(si)
+	return nil
+}
 
-        err := w.pool.WithConnection(ctx, func(c *rconv2.Connection) error {
-                return c.PunishPlayer(ctx, id, message)
-        })
-        if err != nil {
-                w.l.Error("punish-player", "player_id", id, "error", err)
-                return
-        }
-        w.l.Info("punish-player", "player", o.Name, "grid", o.LastGrid.String())
+// updateSession replaces the session snapshot. As it waits for the evaluation loop when the map changed, it must not be
+// called from the command queue, which the loop might be waiting for.
+func (w *Worker) updateSession(si *api.GetSessionResponse) {
+	prev := w.session.Load()
+	if prev != nil && prev.info.MapName != si.MapName {
+		w.l.Info("map-changed", "old_map", prev.info.MapName, "new_map", si.MapName)
+		now := time.Now()
+		w.lastMapChange.Store(now)
+		w.matchStartedAt.Store(now)
+		w.do(w.resetMatch)
+		select {
+		case w.restartCh <- struct{}{}:
+			w.l.Info("signaled-restart-on-map-change")
+		default:
+			w.l.Warn("restart-channel-full")
+		}
+	}
+	set, _ := w.c.FenceSet(w.fenceSet.Load())
+	next := newSession(si, set, prev)
+	if prev != nil && !reflect.DeepEqual(prev.fences(), next.fences()) {
+		w.l.Info("fences-changed", "player_count", si.PlayerCount)
+		w.fencesChangedAt.Store(time.Now())
+	}
+	w.session.Store(next)
+}
 
-        time.Sleep(5 * time.Second)
-        w.outsidePlayers.Delete(id)
+func (w *Worker) checkInactivity(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			w.inactivityTicker.Stop()
+			return
+		case <-w.inactivityTicker.C:
+			if time.Since(w.lastMapChange.Load()) >= w.c.InactivityRestart() {
+				err := w.rcon(ctx, priorityPoll, "players", func(c connection) error {
+					players, err := c.Players(ctx)
+					if err != nil {
+						return err
+					}
+					if len(players.Players) == 0 {
+						w.l.Info("no-players-inactivity-restart")
+						select {
+						case w.restartCh <- struct{}{}:
+							w.l.Info("signaled-restart-on-inactivity")
+							w.lastMapChange.Store(time.Now())
+						default:
+							w.l.Warn("restart-channel-full")
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					w.l.Error("check-inactivity", "error", err)
+				}
+			}
+		}
+	}
 }
 
+// pollSession polls the session of the server, less often while the server is empty. It owns the session snapshot and
+// is the only one refreshing it, see refreshSession.
 func (w *Worker) pollSession(ctx context.Context) {
-        for {
-                select {
-                case <-ctx.Done():
-                        w.sessionTicker.Stop()
-                        return
-                case <-w.sessionTicker.C:
-                        if err := w.populateSession(ctx); err != nil {
-                                w.l.Error("poll-session", "error", err)
-                        }
-                }
-        }
+	interval := w.c.SessionInterval()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			w.sessionTicker.Stop()
+			return
+		case done := <-w.refresh:
+			err = w.populateSession(ctx)
+			done <- err
+		case <-w.sessionTicker.C:
+			if err = w.populateSession(ctx); err != nil {
+				w.l.Error("poll-session", "error", err)
+			}
+		}
+		if err != nil {
+			continue
+		}
+		next := w.c.SessionInterval()
+		if s := w.session.Load(); s.info.PlayerCount == 0 {
+			next = max(next, w.c.IdleInterval())
+		}
+		if next != interval {
+			w.l.Debug("session-poll-interval", "interval", next)
+			interval = next
+			w.sessionTicker.Reset(interval)
+		}
+	}
 }
 
-func (w *Worker) pollPlayers(ctx context.Context) {
-        for {
-                select {
-                case <-ctx.Done():
-                        w.playerTicker.Stop()
-                        return
-                case <-w.playerTicker.C:
-                        if len(w.alliesFences) == 0 && len(w.axisFences) == 0 {
-                                continue
-                        }
-
-                        err := w.pool.WithConnection(ctx, func(c *rconv2.Connection) error {
-                                players, err := c.Players(ctx)
-                                if err != nil {
-                                        return err
-                                }
-                                for _, player := range players.Players {
-                                        go w.checkPlayer(ctx, player)
-                                }
-                                w.trackedPlayers.Range(func(id string, _ struct{}) bool {
-                                        found := false
-                                        for _, player := range players.Players {
-                                                if player.Id == id {
-                                                        found = true
-                                                        break
-                                                }
-                                        }
-                                        if !found {
-                                                w.trackedPlayers.Delete(id)
-                                                w.outsidePlayers.Delete(id)
-                                        }
-                                        return true
-                                })
-                                return nil
-                        })
-                        if err != nil {
-                                w.l.Error("poll-players", "error", err)
-                        }
-                }
-        }
+// refreshSession lets pollSession refresh the session snapshot right away, e.g. after the fence set changed, and waits
+// until it is refreshed.
+func (w *Worker) refreshSession(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case w.refresh <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (w *Worker) checkPlayer(ctx context.Context, p api.GetPlayerResponse) {
-        // Skip whitelisted players
-        if slices.Contains(w.c.GetWhitelist(), p.Id) {
-                w.outsidePlayers.Delete(p.Id)
-                w.trackedPlayers.Delete(p.Id)
-                return
-        }
-
-        if !p.Position.IsSpawned() {
-                return
-        }
-
-        var fences []data.Fence
-        if slices.Contains(alliedTeams, p.Team) {
-                fences = w.alliesFences
-        } else if slices.Contains(axisTeams, p.Team) {
-                fences = w.axisFences
-        }
-        if len(fences) == 0 {
-                return
-        }
+// evaluationLoop owns the enforcement state of all players. On every tick it fetches the players of the server and
+// evaluates all of them in a single pass against one snapshot of the session. Functions passed to do and post are run
+// in between ticks.
+func (w *Worker) evaluationLoop(ctx context.Context) {
+	defer func() {
+		close(w.done)
+		w.punishments.Stop()
+	}()
+	interval := w.c.PlayersInterval()
+	for {
+		select {
+		case <-ctx.Done():
+			w.playerTicker.Stop()
+			return
+		case f := <-w.control:
+			f()
+		case <-w.wake:
+			w.runPosted()
+		case <-w.playerTicker.C:
+			if next := w.evaluatePlayers(ctx); next != interval {
+				w.l.Debug("player-poll-interval", "interval", next)
+				interval = next
+				w.playerTicker.Reset(interval)
+			}
+		}
+	}
+}
 
-        g := p.Position.Grid(w.current)
-        insideFence := false
-        for _, f := range fences {
-                if f.Includes(g) {
-                        insideFence = true
-                        break
-                }
-        }
+// evaluatePlayers fetches and evaluates the players of the server and returns the interval until the next poll. It
+// must only be called from the evaluation loop.
+func (w *Worker) evaluatePlayers(ctx context.Context) time.Duration {
+	s := w.session.Load()
+	if !s.hasFences() || w.suspended() || s.info.PlayerCount == 0 {
+		return max(w.c.PlayersInterval(), w.c.IdleInterval())
+	}
+	if s.geometry == nil {
+		w.skipUnknownMap(s.info)
+		return max(w.c.PlayersInterval(), w.c.IdleInterval())
+	}
+	w.unknownMap = ""
 
-        // Start tracking player only after they enter an allowed fence
-        if insideFence {
-                w.trackedPlayers.Store(p.Id, struct{}{})
-                w.outsidePlayers.Delete(p.Id)
-                return
-        }
+	var players []api.GetPlayerResponse
+	err := w.rcon(ctx, priorityPoll, "players", func(c connection) error {
+		p, err := c.Players(ctx)
+		if err != nil {
+			return err
+		}
+		players = p.Players
+		return nil
+	})
+	if err != nil {
+		w.l.Error("poll-players", "error", err)
+		return w.c.PlayersInterval()
+	}
+	for _, a := range w.evaluate(ctx, s, players, time.Now()) {
+		w.enqueue(ctx, a)
+	}
+	return w.playerInterval()
+}
 
-        // Only process players who are already tracked (i.e., have entered an allowed fence before)
-        if _, ok := w.trackedPlayers.Load(p.Id); !ok {
-                return
-        }
+// skipUnknownMap ends the out-of-bounds periods of all players when the grid of the current map and game mode is not
+// known, as players can not be located on it and would all be considered outside of the fences. It is logged once per
+// map and game mode. It must only be called from the evaluation loop.
+func (w *Worker) skipUnknownMap(si *api.GetSessionResponse) {
+	if key := si.MapName + "/" + si.GameMode; key != w.unknownMap {
+		w.unknownMap = key
+		w.l.Warn("unknown-map-not-evaluated", "map", si.MapName, "game_mode", si.GameMode)
+		w.resetOutsidePlayers()
+	}
+}
 
-        // Player is outside allowed fences and is tracked
-        if o, ok := w.outsidePlayers.Load(p.Id); ok {
-                o.LastGrid = g
-                w.outsidePlayers.Store(p.Id, o)
-                return
-        }
+// playerInterval returns the interval of player polls, which is shorter while players are outside or next to the
+// boundary of the fences. It must only be called from the evaluation loop.
+func (w *Worker) playerInterval() time.Duration {
+	if w.outside.Load() != 0 || w.nearBoundary != 0 {
+		return w.c.BoundaryInterval()
+	}
+	return w.c.PlayersInterval()
+}
 
-        w.outsidePlayers.Store(p.Id, outsidePlayer{FirstOutside: time.Now(), Name: p.Name, LastGrid: g})
-        w.l.Info("player-outside-fence", "player", p.Name, "grid", g)
+// do runs f in the evaluation loop, which is the only place the state of players can be modified in. It blocks until
+// the loop picked up f, or returns immediately when the loop already stopped.
+func (w *Worker) do(f func()) {
+	select {
+	case w.control <- f:
+	case <-w.done:
+	}
+}
 
-        message := w.c.WarningMessage()
-        w.l.Debug("warning-message-final", "message", message)
+// post runs f in the evaluation loop without waiting for it. Other than do, it can be called from the command queue
+// and the evaluation loop itself, e.g. with the result of a command, as the loop might be waiting for the queue.
+func (w *Worker) post(f func()) {
+	w.postMu.Lock()
+	w.posted = append(w.posted, f)
+	w.postMu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
 
-        err := w.pool.WithConnection(ctx, func(c *rconv2.Connection) error {
-                return c.MessagePlayer(ctx, p.Name, message)
-        })
-        if err != nil {
-                w.l.Error("message-player-outside-fence", "player", p.Name, "grid", g, "error", err)
-        }
+// runPosted runs the functions passed to post in the order they were posted. It must only be called from the
+// evaluation loop.
+func (w *Worker) runPosted() {
+	w.postMu.Lock()
+	posted := w.posted
+	w.posted = nil
+	w.postMu.Unlock()
+	for _, f := range posted {
+		f()
+	}
 }
 
-func (w *Worker) applicableFences(f []data.Fence) (v []data.Fence) {
-        for _, fence := range f {
-                if fence.Matches(w.current) {
-                        v = append(v, fence)
-                }
-        }
-        return
+// suspended reports whether the enforcement of fences was disabled or paused with an admin command.
+func (w *Worker) suspended() bool {
+	return w.disabled.Load() || time.Now().Before(w.pausedUntil.Load())
 }
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/floriansw/go-hll-rcon/rconv2"
+	"github.com/floriansw/go-hll-rcon/rconv2/api"
+	"github.com/floriansw/hll-geofences/data"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("evaluatePlayers", func() {
+	var w *Worker
+	var server *fakeServer
+	var log *gbytes.Buffer
+	var cancel context.CancelFunc
+	ctx := context.Background()
+	unknown := &api.GetSessionResponse{MapName: "CARENTAN", GameMode: "Unknown", PlayerCount: 10}
+	outside := api.Grid{X: "A", Y: 5, Numpad: 5}
+
+	BeforeEach(func() {
+		c := data.Server{AlliesFence: []data.Fence{{X: Pointer("E")}}}
+		w = newTestWorker(c)
+		log = gbytes.NewBuffer()
+		w.l = slog.New(slog.NewTextHandler(log, nil))
+		server = newFakeServer(carentan)
+		server.setPlayers(player("1", outside))
+		var runCtx context.Context
+		runCtx, cancel = context.WithCancel(ctx)
+		runWorker(runCtx, w, server)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("does not evaluate players in game modes without a known grid", func() {
+		set, _ := w.c.FenceSet("")
+		server.setSession(unknown)
+		w.session.Store(newSession(unknown, set, nil))
+		inLoop(w, func() {
+			w.players["1"] = &playerState{Tracked: true, Outside: &outsidePlayer{FirstOutside: time.Now()}}
+			w.schedulePunishment(ctx, "1", time.Now().Add(time.Hour))
+		})
+
+		inLoop(w, func() { w.evaluatePlayers(ctx) })
+		inLoop(w, func() { w.evaluatePlayers(ctx) })
+
+		Expect(server.Polls()).To(BeZero())
+		Expect(server.Messages()).To(BeEmpty())
+		inLoop(w, func() { Expect(w.players["1"].Outside).To(BeNil()) })
+		_, ok := w.punishments.Deadline("1")
+		Expect(ok).To(BeFalse())
+		Expect(strings.Count(string(log.Contents()), "unknown-map-not-evaluated")).To(Equal(1))
+
+		server.setSession(carentan)
+		w.session.Store(newSession(carentan, set, nil))
+		inLoop(w, func() { w.evaluatePlayers(ctx) })
+		Expect(server.Polls()).To(Equal(1))
+	})
+})
+
+var _ = Describe("evaluationLoop", func() {
+	var w *Worker
+	var server *fakeServer
+	var cancel context.CancelFunc
+	inside := api.Grid{X: "E", Y: 5, Numpad: 5}
+	outside := api.Grid{X: "A", Y: 5, Numpad: 5}
+
+	BeforeEach(func() {
+		w = newTestWorker(data.Server{
+			AlliesFence:        []data.Fence{{X: Pointer("E")}},
+			PunishAfterSeconds: Pointer(0),
+			Positions:          &data.Positions{MaxSpeedMetersPerSecond: Pointer(1000.0)},
+			Polling:            &data.Polling{PlayersMilliseconds: Pointer(10)},
+			Connection:         &data.Connection{MaxOpenConnections: Pointer(1)},
+		})
+		server = newFakeServer(carentan)
+		server.limitConnections(1)
+		server.setPlayers(player("1", inside))
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		runWorker(ctx, w, server)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("keeps polling players after punishing them", func() {
+		Eventually(server.Polls).Should(BeNumerically(">=", 2))
+		// Commands take longer than the poll interval, so that the loop waits for a poll while the punishment runs
+		server.setLatency(30 * time.Millisecond)
+		server.setPlayers(player("1", outside))
+
+		Eventually(server.Punished).Should(ContainElement("1"))
+		polls := server.Polls()
+		Eventually(server.Polls).Should(BeNumerically(">", polls+2))
+	})
+
+	It("keeps polling players after a map change", func() {
+		server.setLatency(30 * time.Millisecond)
+		Eventually(server.Polls).Should(BeNumerically(">=", 2))
+		server.setSession(foy)
+
+		Expect(w.refreshSession(context.Background())).To(Succeed())
+
+		Expect(w.session.Load().info.MapName).To(Equal("FOY"))
+		polls := server.Polls()
+		Eventually(server.Polls).Should(BeNumerically(">", polls+2))
+	})
+
+	// punishedAgain expects the punishment of the player to be scheduled again after it failed.
+	punishedAgain := func() {
+		Eventually(func() int {
+			failures := 0
+			inLoop(w, func() {
+				if st, ok := w.players["1"]; ok && st.Outside != nil {
+					failures = st.Outside.PunishFailures
+				}
+			})
+			return failures
+		}).Should(Equal(1))
+		deadline, ok := w.punishments.Deadline("1")
+		Expect(ok).To(BeTrue())
+		Expect(deadline).To(BeTemporally("~", time.Now().Add(punishRetryDelay), time.Second))
+		Expect(server.Punished()).To(BeEmpty())
+	}
+
+	It("punishes players again after the punishment failed", func() {
+		server.setPunishErr(rconv2.NewUnexpectedStatus(500, "internal error"))
+		Eventually(server.Polls).Should(BeNumerically(">=", 2))
+		server.setPlayers(player("1", outside))
+
+		punishedAgain()
+	})
+
+	It("punishes players again after the punishment was dropped", func() {
+		w.queue.mu.Lock()
+		w.queue.o.MaxPending = 0
+		w.queue.mu.Unlock()
+		Eventually(server.Polls).Should(BeNumerically(">=", 2))
+		server.setPlayers(player("1", outside))
+
+		punishedAgain()
+	})
+})